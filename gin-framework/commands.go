@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gin-framework/config"
+	"gin-framework/database"
+	"gin-framework/repository"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+// runCommand runs a CLI subcommand against the configured database
+// instead of starting the server, e.g.
+//
+//	go run . -db db.json snapshot list
+//
+// db is nil for the memory backend. open loads the backend for commands
+// that need it; the others work on the file alone, so they still run when
+// the content is too broken to load.
+func runCommand(cfg *config.Config, db *database.JSONDatabase, ids repository.IDGenerator, cipher *database.Cipher, open func() (*backend, error), args []string) error {
+	if db == nil {
+		return fmt.Errorf("%s needs a file-backed storage backend, not %q", args[0], cfg.Backend)
+	}
+
+	switch args[0] {
+	case "snapshot":
+		store, err := open()
+		if err != nil {
+			return err
+		}
+		return snapshotCommand(cfg, store, args[1:])
+	case "convert":
+		return convertCommand(cfg, db, args[1:])
+	case "verify":
+		if err := foldLog(cfg, db, cipher); err != nil {
+			return err
+		}
+		return verifyCommand(cfg, db, ids, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q (available: snapshot, convert, verify)", args[0])
+	}
+}
+
+// snapshotCommand handles "snapshot create", "snapshot list" and
+// "snapshot restore <name>"
+func snapshotCommand(cfg *config.Config, store *backend, args []string) error {
+	snapshots := repository.NewSnapshotter(store.db, store.repo, cfg.BackupDir)
+
+	if len(args) == 0 {
+		return fmt.Errorf("usage: snapshot create | list | restore <name>")
+	}
+	switch args[0] {
+	case "create":
+		info, err := snapshots.Create(repository.SnapshotManual)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created %s (%d bytes)\n", info.Name, info.Size)
+	case "list":
+		list, err := snapshots.List()
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			fmt.Printf("No snapshots in %s\n", snapshots.Dir())
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tCREATED\tSIZE")
+		for _, s := range list {
+			fmt.Fprintf(w, "%s\t%s\t%d\n", s.Name, s.CreatedAt.Local().Format(time.RFC3339), s.Size)
+		}
+		return w.Flush()
+	case "restore":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: snapshot restore <name>")
+		}
+		if err := snapshots.Restore(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Restored %s from %s\n", cfg.DBPath, args[1])
+	default:
+		return fmt.Errorf("unknown snapshot command %q (available: create, list, restore)", args[0])
+	}
+	return nil
+}
+
+// convertCommand handles "convert <codec>", which rewrites the database
+// file in another format
+func convertCommand(cfg *config.Config, db *database.JSONDatabase, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: convert <codec>")
+	}
+	codec, err := database.CodecByName(args[0])
+	if err != nil {
+		return err
+	}
+
+	before, err := os.Stat(cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	from, err := db.Convert(codec)
+	if err != nil {
+		return err
+	}
+	after, err := os.Stat(cfg.DBPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Converted %s from %s to %s (%d -> %d bytes)\n", cfg.DBPath, from, codec.Name(), before.Size(), after.Size())
+	if codec.Name() != cfg.Codec {
+		fmt.Printf("Start the server with -codec %s to keep writing it in this format\n", codec.Name())
+	}
+	return nil
+}
+
+// verifyCommand handles "verify [-repair] [-report <file>]". It fails when
+// violations are left, so scripts can check the exit status.
+func verifyCommand(cfg *config.Config, db *database.JSONDatabase, ids repository.IDGenerator, args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "fix what can be fixed safely and write a report")
+	reportPath := flags.String("report", "", "where the repair report is written (default: <db>.repair-<time>.json)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if !*repair {
+		violations, err := repository.Verify(db)
+		if err != nil {
+			return err
+		}
+		for _, v := range violations {
+			fmt.Println(v)
+		}
+		if len(violations) > 0 {
+			return fmt.Errorf("%d violation(s) in %s; run verify -repair to fix what can be fixed", len(violations), cfg.DBPath)
+		}
+		fmt.Printf("%s is consistent\n", cfg.DBPath)
+		return nil
+	}
+
+	report, err := repository.Repair(db, ids)
+	if err != nil {
+		return err
+	}
+	for _, v := range report.Violations {
+		fmt.Println(v)
+	}
+	path, err := writeRepairReport(cfg, report, *reportPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Fixed %d violation(s), %d left; report written to %s\n", report.Fixed, report.Remaining, path)
+	if report.Remaining > 0 {
+		return fmt.Errorf("%d violation(s) need a manual fix", report.Remaining)
+	}
+	return nil
+}
+
+// writeRepairReport saves report as JSON at path, or next to the database
+// when path is empty, and returns where it went
+func writeRepairReport(cfg *config.Config, report *repository.RepairReport, path string) (string, error) {
+	if path == "" {
+		path = filepath.Join(filepath.Dir(cfg.DBPath), filepath.Base(cfg.DBPath)+".repair-"+report.RepairedAt.Format("20060102T150405Z")+".json")
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return path, ioutil.WriteFile(path, data, 0644)
+}
+
+// foldLog writes the records in the WAL backend's log into db.json, so
+// that the integrity check sees the state the backend
+// will replay and Repair raises the sequence past IDs that so far only
+// exist in the log. Other backends keep everything in db.json already.
+func foldLog(cfg *config.Config, db *database.JSONDatabase, cipher *database.Cipher) error {
+	if db == nil || cfg.Backend != config.BackendWAL {
+		return nil
+	}
+	wal, err := database.OpenLog(cfg.WALPath, cipher)
+	if err != nil {
+		return err
+	}
+	defer wal.Close()
+
+	if err := repository.FoldLog(db, wal); err != nil {
+		return fmt.Errorf("folding %s into %s: %w", cfg.WALPath, cfg.DBPath, err)
+	}
+	return nil
+}
+
+// startupCheck verifies the database before the backends load it and
+// handles violations as configured. With the WAL backend the log is first
+// folded into the file, see foldLog.
+func startupCheck(cfg *config.Config, db *database.JSONDatabase, ids repository.IDGenerator, cipher *database.Cipher) error {
+	if db == nil || cfg.StartupCheck == config.CheckOff {
+		return nil
+	}
+	if err := foldLog(cfg, db, cipher); err != nil {
+		return err
+	}
+
+	switch cfg.StartupCheck {
+	case config.CheckRepair:
+		report, err := repository.Repair(db, ids)
+		if err != nil {
+			return err
+		}
+		if len(report.Violations) == 0 {
+			return nil
+		}
+		for _, v := range report.Violations {
+			log.Printf("WARNING: %s: %s", cfg.DBPath, v)
+		}
+		if report.Fixed > 0 {
+			path, err := writeRepairReport(cfg, report, "")
+			if err != nil {
+				return err
+			}
+			log.Printf("Repaired %d violation(s) in %s, report written to %s", report.Fixed, cfg.DBPath, path)
+		}
+		return nil
+	}
+
+	violations, err := repository.Verify(db)
+	if err != nil {
+		return err
+	}
+	for _, v := range violations {
+		log.Printf("WARNING: %s: %s", cfg.DBPath, v)
+	}
+	if len(violations) > 0 && cfg.StartupCheck == config.CheckFail {
+		return fmt.Errorf("%d integrity violation(s) in %s; run verify -repair", len(violations), cfg.DBPath)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"gin-framework/repository"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Storage backends understood by main
+const (
+	BackendJSON    = "json"
+	BackendIndexed = "indexed"
+	BackendMemory  = "memory"
+	BackendWAL     = "wal"
+)
+
+// What the startup integrity check does about violations
+const (
+	CheckOff    = "off"
+	CheckWarn   = "warn"   // log them and start anyway
+	CheckFail   = "fail"   // refuse to start
+	CheckRepair = "repair" // fix what can be fixed, log the rest and start
+)
+
+type Config struct {
+	Addr    string // HTTP listen address
+	Backend string // Storage backend: json, indexed, memory or wal
+	DBPath  string // Database file for the json backend
+	Codec   string // File format of db.json, e.g. json, json-compact, gob or gob+gzip
+
+	WatchInterval time.Duration // How often db.json is polled for external edits, 0 disables
+	LockTimeout   time.Duration // How long to wait for another process's file lock
+	StartupCheck  string        // Integrity check of db.json at startup: off, warn, fail or repair
+
+	MigrateDryRun bool     // Print pending schema migrations and exit
+	Command       []string // CLI subcommand and its arguments, e.g. "snapshot list"; empty runs the server
+
+	BackupDir      string        // Directory for database snapshots
+	BackupInterval time.Duration // How often a scheduled snapshot is taken, 0 disables
+	BackupKeep     int           // How many scheduled snapshots are kept
+	AdminToken     string        // Bearer token required by the admin endpoints, empty leaves them open
+
+	EncryptionKey     string // Key that encrypts db.json at rest (hex or base64), empty for plaintext
+	EncryptionKeyFile string // File holding the key on its first line and old keys on the next ones
+	OldEncryptionKeys string // Comma-separated keys still accepted for reading after a rotation
+
+	IDStrategy    string // How task IDs are generated, one of repository.IDStrategies
+	RevisionsPath string // Append-only journal of task revisions
+
+	TrashRetention     time.Duration // How long deleted tasks stay in the trash
+	TrashPurgeInterval time.Duration // How often expired trash is purged, 0 disables
+
+	Follow             string // Leader base URL; set, the server runs as a read-only follower of it
+	ReplicationLogSize int    // How many recent changes the leader keeps for followers
+
+	WALPath        string // Append-only log for the wal backend
+	WALCompactSize int64  // Log size in bytes that triggers a snapshot
+}
+
+// Load reads the configuration from command-line flags, falling back to
+// environment variables and then to the defaults
+func Load() (*Config, error) {
+	cfg := &Config{}
+
+	flag.StringVar(&cfg.Addr, "addr", getEnv("ADDR", ":8080"), "HTTP listen address")
+	flag.StringVar(&cfg.Backend, "backend", getEnv("STORAGE_BACKEND", BackendIndexed), "storage backend (json, indexed, memory, wal)")
+	flag.StringVar(&cfg.DBPath, "db", getEnv("DB_PATH", "db.json"), "path of the JSON database file")
+	flag.StringVar(&cfg.Codec, "codec", getEnv("DB_CODEC", "json"), "file format db.json is written in (json, json-compact, gob, each optionally +gzip)")
+	flag.DurationVar(&cfg.WatchInterval, "watch-interval", getEnvDuration("DB_WATCH_INTERVAL", 2*time.Second), "how often to check db.json for external edits (0 disables)")
+	flag.DurationVar(&cfg.LockTimeout, "lock-timeout", getEnvDuration("DB_LOCK_TIMEOUT", 5*time.Second), "how long to wait for the db.json file lock held by another process")
+	flag.StringVar(&cfg.StartupCheck, "startup-check", getEnv("DB_STARTUP_CHECK", CheckWarn), "integrity check of db.json at startup (off, warn, fail, repair)")
+	flag.StringVar(&cfg.EncryptionKey, "encryption-key", getEnv("DB_ENCRYPTION_KEY", ""), "32-byte key as hex or base64 to encrypt db.json at rest (prefer the env var or a key file)")
+	flag.StringVar(&cfg.EncryptionKeyFile, "encryption-key-file", getEnv("DB_ENCRYPTION_KEY_FILE", ""), "file with the encryption key on the first line and old keys on the following lines")
+	flag.StringVar(&cfg.OldEncryptionKeys, "old-encryption-keys", getEnv("DB_OLD_ENCRYPTION_KEYS", ""), "comma-separated old keys to read data written before a key rotation")
+	flag.BoolVar(&cfg.MigrateDryRun, "migrate-dry-run", false, "print the schema migrations that would run on the database and exit")
+	flag.StringVar(&cfg.IDStrategy, "id-strategy", getEnv("TASK_ID_STRATEGY", repository.IDSequence), "how new task IDs are generated ("+strings.Join(repository.IDStrategies, ", ")+")")
+	flag.DurationVar(&cfg.TrashRetention, "trash-retention", getEnvDuration("TRASH_RETENTION", 30*24*time.Hour), "how long deleted tasks are kept in the trash before they are purged")
+	flag.DurationVar(&cfg.TrashPurgeInterval, "trash-purge-interval", getEnvDuration("TRASH_PURGE_INTERVAL", time.Hour), "how often expired tasks are purged from the trash (0 disables)")
+	flag.StringVar(&cfg.BackupDir, "backup-dir", getEnv("BACKUP_DIR", "backups"), "directory for database snapshots")
+	flag.DurationVar(&cfg.BackupInterval, "backup-interval", getEnvDuration("BACKUP_INTERVAL", 0), "how often a scheduled snapshot is taken (0 disables)")
+	flag.IntVar(&cfg.BackupKeep, "backup-keep", int(getEnvInt("BACKUP_KEEP", 7)), "how many scheduled snapshots are kept")
+	flag.StringVar(&cfg.AdminToken, "admin-token", getEnv("ADMIN_TOKEN", ""), "bearer token required by the /api/admin endpoints (empty leaves them open)")
+	flag.StringVar(&cfg.Follow, "follow", getEnv("FOLLOW_LEADER", ""), "base URL of a leader to replicate from; the server then runs as a read-only follower")
+	flag.IntVar(&cfg.ReplicationLogSize, "replication-log-size", int(getEnvInt("REPLICATION_LOG_SIZE", 10000)), "how many recent changes are kept for followers to catch up from")
+	flag.StringVar(&cfg.RevisionsPath, "revisions", getEnv("REVISIONS_PATH", ""), "path of the task revision journal (default: <db>.revisions)")
+	flag.StringVar(&cfg.WALPath, "wal", getEnv("WAL_PATH", ""), "path of the write-ahead log (default: <db>.wal)")
+	flag.Int64Var(&cfg.WALCompactSize, "wal-compact-size", getEnvInt("WAL_COMPACT_SIZE", 1<<20), "log size in bytes that triggers snapshot compaction")
+	flag.Parse()
+	cfg.Command = flag.Args()
+
+	switch cfg.Backend {
+	case BackendJSON, BackendIndexed, BackendMemory, BackendWAL:
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+
+	switch cfg.StartupCheck {
+	case CheckOff, CheckWarn, CheckFail, CheckRepair:
+	default:
+		return nil, fmt.Errorf("unknown startup check %q", cfg.StartupCheck)
+	}
+
+	if _, err := repository.NewIDGenerator(cfg.IDStrategy); err != nil {
+		return nil, err
+	}
+
+	if cfg.EncryptionKey != "" && cfg.EncryptionKeyFile != "" {
+		return nil, fmt.Errorf("encryption-key and encryption-key-file are mutually exclusive")
+	}
+	if cfg.OldEncryptionKeys != "" && cfg.EncryptionKey == "" && cfg.EncryptionKeyFile == "" {
+		return nil, fmt.Errorf("old-encryption-keys needs a current key from encryption-key or encryption-key-file")
+	}
+
+	if cfg.TrashRetention < 0 {
+		return nil, fmt.Errorf("trash-retention must not be negative, got %s", cfg.TrashRetention)
+	}
+
+	if cfg.BackupKeep < 1 {
+		return nil, fmt.Errorf("backup-keep must be at least 1, got %d", cfg.BackupKeep)
+	}
+
+	if cfg.Follow != "" {
+		u, err := url.Parse(cfg.Follow)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("follow must be an http or https URL, got %q", cfg.Follow)
+		}
+	}
+	if cfg.ReplicationLogSize < 1 {
+		return nil, fmt.Errorf("replication-log-size must be at least 1, got %d", cfg.ReplicationLogSize)
+	}
+
+	if cfg.RevisionsPath == "" {
+		cfg.RevisionsPath = cfg.DBPath + ".revisions"
+	}
+	if cfg.WALPath == "" {
+		cfg.WALPath = cfg.DBPath + ".wal"
+	}
+	if cfg.WALCompactSize <= 0 {
+		return nil, fmt.Errorf("wal-compact-size must be positive, got %d", cfg.WALCompactSize)
+	}
+
+	return cfg, nil
+}
+
+// getEnv returns the environment variable or fallback when it is unset
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// getEnvInt is getEnv for integer values; an unparsable value is ignored
+func getEnvInt(key string, fallback int64) int64 {
+	if value, ok := os.LookupEnv(key); ok {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+// getEnvDuration is getEnv for durations like "500ms" or "2s"; an
+// unparsable value is ignored
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
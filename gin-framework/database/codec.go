@@ -0,0 +1,240 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Codec names understood by CodecByName. Any of them can be combined with
+// gzip compression by appending GzipSuffix, e.g. "gob+gzip".
+const (
+	CodecJSON        = "json" // indented JSON, readable and diffable; the default
+	CodecJSONCompact = "json-compact"
+	CodecGob         = "gob"
+	GzipSuffix       = "+gzip"
+)
+
+// Codec turns the database content into file bytes and back. Every codec
+// can read what the others wrote, since Decode is only called after the
+// format was detected; see decodeAny.
+type Codec interface {
+	Name() string
+	Encode(version int, collections map[string]json.RawMessage) ([]byte, error)
+	Decode(data []byte) (version int, collections map[string]json.RawMessage, err error)
+}
+
+// CodecByName returns the codec with the given name
+func CodecByName(name string) (Codec, error) {
+	if base := strings.TrimSuffix(name, GzipSuffix); base != name {
+		inner, err := CodecByName(base)
+		if err != nil {
+			return nil, err
+		}
+		return gzipCodec{inner: inner}, nil
+	}
+
+	switch name {
+	case CodecJSON:
+		return jsonCodec{indent: true}, nil
+	case CodecJSONCompact:
+		return jsonCodec{}, nil
+	case CodecGob:
+		return gobCodec{}, nil
+	default:
+		return nil, fmt.Errorf("database: unknown codec %q", name)
+	}
+}
+
+// jsonCodec writes the schema header layout as JSON
+type jsonCodec struct {
+	indent bool
+}
+
+func (c jsonCodec) Name() string {
+	if c.indent {
+		return CodecJSON
+	}
+	return CodecJSONCompact
+}
+
+func (c jsonCodec) Encode(version int, collections map[string]json.RawMessage) ([]byte, error) {
+	header := fileHeader{SchemaVersion: version, Collections: collections}
+	if !c.indent {
+		return json.Marshal(header)
+	}
+	return json.MarshalIndent(header, "", "  ")
+}
+
+func (c jsonCodec) Decode(data []byte) (int, map[string]json.RawMessage, error) {
+	return decodeFile(data)
+}
+
+// gobMagic starts every gob file. JSON never starts with a NUL byte, so
+// the two are told apart by the first bytes.
+var gobMagic = []byte("\x00gobdb\n")
+
+// gobFile is the gob layout; collections stay JSON inside, as Tx hands
+// them out as JSON
+type gobFile struct {
+	SchemaVersion int
+	Collections   map[string][]byte
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string {
+	return CodecGob
+}
+
+func (gobCodec) Encode(version int, collections map[string]json.RawMessage) ([]byte, error) {
+	file := gobFile{SchemaVersion: version, Collections: make(map[string][]byte, len(collections))}
+	for name, raw := range collections {
+		file.Collections[name] = raw
+	}
+
+	buf := bytes.NewBuffer(append([]byte(nil), gobMagic...))
+	if err := gob.NewEncoder(buf).Encode(file); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Decode(data []byte) (int, map[string]json.RawMessage, error) {
+	if !bytes.HasPrefix(data, gobMagic) {
+		return 0, nil, fmt.Errorf("database: not a gob database file")
+	}
+
+	var file gobFile
+	if err := gob.NewDecoder(bytes.NewReader(data[len(gobMagic):])).Decode(&file); err != nil {
+		return 0, nil, err
+	}
+	collections := make(map[string]json.RawMessage, len(file.Collections))
+	for name, raw := range file.Collections {
+		collections[name] = raw
+	}
+	return file.SchemaVersion, collections, nil
+}
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// gzipCodec compresses the output of another codec
+type gzipCodec struct {
+	inner Codec
+}
+
+func (c gzipCodec) Name() string {
+	return c.inner.Name() + GzipSuffix
+}
+
+func (c gzipCodec) Encode(version int, collections map[string]json.RawMessage) ([]byte, error) {
+	data, err := c.inner.Encode(version, collections)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c gzipCodec) Decode(data []byte) (int, map[string]json.RawMessage, error) {
+	data, err := gunzip(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	return c.inner.Decode(data)
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
+}
+
+// detectCodec names the codec that wrote data, which must already be
+// decrypted
+func detectCodec(data []byte) string {
+	if bytes.HasPrefix(data, gzipMagic) {
+		inner, err := gunzip(data)
+		if err != nil {
+			return "gzip"
+		}
+		return detectCodec(inner) + GzipSuffix
+	}
+	if bytes.HasPrefix(data, gobMagic) {
+		return CodecGob
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 1 && !bytes.ContainsAny(trimmed, "\n") {
+		return CodecJSONCompact
+	}
+	return CodecJSON
+}
+
+// decodeAny decodes data written by any codec, including legacy JSON
+// files without a schema header
+func decodeAny(data []byte) (int, document, error) {
+	if bytes.HasPrefix(data, gzipMagic) {
+		inner, err := gunzip(data)
+		if err != nil {
+			return 0, nil, err
+		}
+		return decodeAny(inner)
+	}
+	if bytes.HasPrefix(data, gobMagic) {
+		version, collections, err := gobCodec{}.Decode(data)
+		return version, collections, err
+	}
+	return decodeFile(data)
+}
+
+// Convert rewrites the file with codec right away and keeps using it for
+// later writes. It returns the name of the codec the file was in before;
+// the old content stays in the .bak generation.
+func (db *JSONDatabase) Convert(codec Codec) (from string, err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.lock.lock(); err != nil {
+		return "", err
+	}
+	defer db.lock.unlock()
+
+	data, err := ioutil.ReadFile(db.filepath)
+	if err != nil {
+		return "", err
+	}
+	plain, _, err := db.cipher.decrypt(data)
+	if err != nil {
+		return "", err
+	}
+	from = detectCodec(plain)
+
+	doc, err := db.read()
+	if err != nil {
+		return "", err
+	}
+	db.codec = codec
+	return from, db.write(doc)
+}
+
+// CodecName returns the name of the codec new content is written with
+func (db *JSONDatabase) CodecName() string {
+	return db.codec.Name()
+}
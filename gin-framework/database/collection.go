@@ -0,0 +1,137 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// legacyCollection receives the content of files written before
+// collections existed, which held a single top-level array of tasks
+const legacyCollection = "tasks"
+
+// document is the parsed database file: the raw JSON of each collection
+// keyed by name, e.g. {"tasks": [...], "users": [...]}
+type document map[string]json.RawMessage
+
+// fileHeader is the top-level layout of the database file
+type fileHeader struct {
+	SchemaVersion int      `json:"schema_version"`
+	Collections   document `json:"collections"`
+}
+
+// decodeFile parses the file content and reports its schema version.
+// Files written before the header existed are version 0: either a bare
+// top-level array (read as the legacy tasks collection) or a plain object
+// of collections. An empty file is a fresh store.
+func decodeFile(data []byte) (int, document, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return CurrentSchemaVersion, document{}, nil
+	}
+
+	if data[0] == '[' {
+		if !json.Valid(data) {
+			return 0, nil, errInvalidJSON
+		}
+		return 0, document{legacyCollection: json.RawMessage(data)}, nil
+	}
+
+	doc := document{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return 0, nil, err
+	}
+	if _, ok := doc["schema_version"]; !ok {
+		return 0, doc, nil
+	}
+
+	var header fileHeader
+	if err := json.Unmarshal(data, &header); err != nil {
+		return 0, nil, err
+	}
+	if header.Collections == nil {
+		header.Collections = document{}
+	}
+	return header.SchemaVersion, header.Collections, nil
+}
+
+// decode reads content written by any codec and possibly encrypted with c
+func decode(c *Cipher, data []byte) (int, document, error) {
+	plain, _, err := c.decrypt(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	return decodeAny(plain)
+}
+
+// encode renders doc with the current schema header using codec, then
+// encrypts it with c, if any
+func encode(codec Codec, c *Cipher, doc document) ([]byte, error) {
+	data, err := codec.Encode(CurrentSchemaVersion, doc)
+	if err != nil {
+		return nil, err
+	}
+	return c.encrypt(data)
+}
+
+func (doc document) names() []string {
+	names := make([]string, 0, len(doc))
+	for name := range doc {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Collection is a named part of the database file, e.g. "tasks" or
+// "users". Adding a resource only needs a new name, no new database code.
+type Collection struct {
+	db   *JSONDatabase
+	name string
+}
+
+// Collection returns the collection with the given name. It does not have
+// to exist yet; it is created by the first Write.
+func (db *JSONDatabase) Collection(name string) *Collection {
+	return &Collection{db: db, name: name}
+}
+
+// Name returns the collection name
+func (c *Collection) Name() string {
+	return c.name
+}
+
+// View runs fn with a read lock and a Tx bound to this collection
+func (c *Collection) View(fn func(tx *Tx) error) error {
+	return c.db.view(c.name, fn)
+}
+
+// Update runs fn with the write lock and a Tx bound to this collection
+func (c *Collection) Update(fn func(tx *Tx) error) error {
+	return c.db.update(c.name, fn)
+}
+
+// Read unmarshals the collection into v
+func (c *Collection) Read(v interface{}) error {
+	return c.View(func(tx *Tx) error {
+		return tx.Read(v)
+	})
+}
+
+// Write replaces the collection with v
+func (c *Collection) Write(v interface{}) error {
+	return c.Update(func(tx *Tx) error {
+		return tx.Write(v)
+	})
+}
+
+// Collections lists the names of all stored collections
+func (db *JSONDatabase) Collections() ([]string, error) {
+	var names []string
+	err := db.View(func(tx *Tx) error {
+		var err error
+		names, err = tx.Collections()
+		return err
+	})
+	return names, err
+}
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+// ErrWrongKey is returned when encrypted data cannot be decrypted with
+// any configured key
+var ErrWrongKey = errors.New("database: wrong encryption key")
+
+// KeySize is the length of an encryption key in bytes (AES-256)
+const KeySize = 32
+
+const cipherName = "AES-256-GCM"
+
+// Cipher encrypts the database file and log with AES-256-GCM. New data is
+// always written under the current key; the old keys are only used to
+// read data written before a key rotation.
+type Cipher struct {
+	current string // ID of the key used for writing
+	keys    map[string]cipher.AEAD
+}
+
+// sealed is the on-disk form of encrypted data. It stays JSON, so the
+// file remains recognizable and the key it needs is named in it.
+type sealed struct {
+	Cipher string `json:"cipher"`
+	KeyID  string `json:"key_id"`
+	Nonce  []byte `json:"nonce"`
+	Data   []byte `json:"data"`
+}
+
+// NewCipher returns a Cipher that writes with current and can also read
+// data written with any of the old keys
+func NewCipher(current []byte, old ...[]byte) (*Cipher, error) {
+	c := &Cipher{keys: make(map[string]cipher.AEAD)}
+	for i, key := range append([][]byte{current}, old...) {
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("database: encryption key must be %d bytes, got %d", KeySize, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		id := KeyID(key)
+		if i == 0 {
+			c.current = id
+		}
+		c.keys[id] = aead
+	}
+	return c, nil
+}
+
+// ParseKey decodes a key given as 64 hex digits or as standard base64
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := hex.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("database: encryption key must be %d bytes as hex or base64", KeySize)
+}
+
+// KeyID is a short fingerprint of key. It is stored next to encrypted
+// data so the right key can be picked, and it reveals nothing about the
+// key itself.
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// CurrentKeyID returns the ID of the key new data is written with
+func (c *Cipher) CurrentKeyID() string {
+	return c.current
+}
+
+// encrypt seals plain under the current key. A nil Cipher leaves the data
+// as it is.
+func (c *Cipher) encrypt(plain []byte) ([]byte, error) {
+	if c == nil {
+		return plain, nil
+	}
+
+	aead := c.keys[c.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	// The key ID is authenticated too, so it cannot be swapped
+	data := aead.Seal(nil, nonce, plain, []byte(c.current))
+
+	return json.Marshal(sealed{Cipher: cipherName, KeyID: c.current, Nonce: nonce, Data: data})
+}
+
+// decrypt opens data written by encrypt and returns plaintext data as it
+// is. keyID is the key it was encrypted with, empty for plaintext.
+func (c *Cipher) decrypt(data []byte) (plain []byte, keyID string, err error) {
+	s, ok := parseSealed(data)
+	if !ok {
+		return data, "", nil
+	}
+
+	if c == nil {
+		return nil, s.KeyID, fmt.Errorf("%w: the data is encrypted with key %s but no encryption key is configured", ErrWrongKey, s.KeyID)
+	}
+	aead, ok := c.keys[s.KeyID]
+	if !ok {
+		return nil, s.KeyID, fmt.Errorf("%w: the data is encrypted with key %s, which is not among the configured keys", ErrWrongKey, s.KeyID)
+	}
+	plain, err = aead.Open(nil, s.Nonce, s.Data, []byte(s.KeyID))
+	if err != nil {
+		return nil, s.KeyID, fmt.Errorf("%w: authentication with key %s failed, the data is corrupt or was tampered with", ErrWrongKey, s.KeyID)
+	}
+	return plain, s.KeyID, nil
+}
+
+// parseSealed recognizes data written by encrypt
+func parseSealed(data []byte) (sealed, bool) {
+	var s sealed
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' || json.Unmarshal(data, &s) != nil {
+		return s, false
+	}
+	return s, s.Cipher == cipherName && s.KeyID != "" && s.Data != nil
+}
+
+// encryptFile rewrites the file under the current key when it is stored
+// in plaintext or under an old key. The .bak generation is replaced as
+// well, so no copy in plaintext or under an old key is left behind.
+// Callers must hold the file lock.
+func (db *JSONDatabase) encryptFile() error {
+	if db.cipher == nil {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(db.filepath)
+	if err != nil {
+		return err
+	}
+	_, keyID, err := db.cipher.decrypt(data)
+	if err != nil {
+		return err
+	}
+
+	if keyID != db.cipher.current {
+		_, doc, err := decode(db.cipher, data)
+		if err != nil {
+			return err
+		}
+		if err := db.write(doc); err != nil {
+			return err
+		}
+
+		if keyID == "" {
+			log.Printf("Encrypted %s with key %s", db.filepath, db.cipher.current)
+		} else {
+			log.Printf("Re-encrypted %s from key %s to key %s", db.filepath, keyID, db.cipher.current)
+		}
+	}
+
+	backup, err := ioutil.ReadFile(db.backupPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if s, ok := parseSealed(backup); ok && s.KeyID == db.cipher.current {
+		return nil
+	}
+	// The previous generation would still expose the old content
+	return db.keepBackup()
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	keyA = bytes.Repeat([]byte{0xa}, KeySize)
+	keyB = bytes.Repeat([]byte{0xb}, KeySize)
+)
+
+func newTestCipher(t *testing.T, current []byte, old ...[]byte) *Cipher {
+	t.Helper()
+	c, err := NewCipher(current, old...)
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	return c
+}
+
+// createEncrypted writes one collection to a new database under c
+func createEncrypted(t *testing.T, path string, c *Cipher) {
+	t.Helper()
+	db, err := Open(path, &Options{Cipher: c})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	if err := db.Collection("tasks").Write([]string{"secret"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+}
+
+// fileKeyID returns the key the file at path is sealed with
+func fileKeyID(t *testing.T, path string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	s, ok := parseSealed(data)
+	if !ok {
+		t.Fatalf("%s is not encrypted", path)
+	}
+	return s.KeyID
+}
+
+func TestOpenWithWrongKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json")
+	createEncrypted(t, path, newTestCipher(t, keyA))
+
+	tests := []struct {
+		name   string
+		cipher *Cipher
+	}{
+		{"other key", newTestCipher(t, keyB)},
+		{"no key", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := Open(path, &Options{Cipher: tt.cipher})
+			if err == nil {
+				db.Close()
+				t.Fatal("Open succeeded")
+			}
+			if !errors.Is(err, ErrWrongKey) {
+				t.Fatalf("Open error = %v, want ErrWrongKey", err)
+			}
+		})
+	}
+}
+
+func TestOpenRotatesKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json")
+	createEncrypted(t, path, newTestCipher(t, keyA))
+	// A second write leaves a .bak generation under key A as well
+	createEncrypted(t, path, newTestCipher(t, keyA))
+
+	db, err := Open(path, &Options{Cipher: newTestCipher(t, keyB, keyA)})
+	if err != nil {
+		t.Fatalf("Open with the old key among the keys: %v", err)
+	}
+	var got []string
+	if err := db.Collection("tasks").Read(&got); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	db.Close()
+	if len(got) != 1 || got[0] != "secret" {
+		t.Fatalf("Read = %q, want [secret]", got)
+	}
+
+	for _, p := range []string{path, path + ".bak"} {
+		if id := fileKeyID(t, p); id != KeyID(keyB) {
+			t.Errorf("%s is under key %s, want the new key %s", filepath.Base(p), id, KeyID(keyB))
+		}
+	}
+
+	// The old key alone no longer opens it, the new one alone does
+	if _, err := Open(path, &Options{Cipher: newTestCipher(t, keyA)}); !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("Open with only the old key: error = %v, want ErrWrongKey", err)
+	}
+	db, err = Open(path, &Options{Cipher: newTestCipher(t, keyB)})
+	if err != nil {
+		t.Fatalf("Open with only the new key: %v", err)
+	}
+	db.Close()
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	c := newTestCipher(t, keyA)
+	data, err := c.encrypt([]byte(`{"tasks":[]}`))
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	s, _ := parseSealed(data)
+
+	s.Data[0] ^= 1
+	tampered, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.decrypt(tampered); !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("decrypt of tampered data: error = %v, want ErrWrongKey", err)
+	}
+}
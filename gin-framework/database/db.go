@@ -1,56 +1,368 @@
 package database
 
 import (
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"sync"
+	"time"
 )
 
+// ErrReadOnlyTx is returned when Write is called inside View
+var ErrReadOnlyTx = errors.New("database: write inside read-only transaction")
+
+// ErrNoCollection is returned by Read and Write on a Tx from View or
+// Update that was not bound with Collection
+var ErrNoCollection = errors.New("database: no collection selected")
+
+var errInvalidJSON = errors.New("content is not valid JSON")
+
+// DefaultLockTimeout is used when Options.LockTimeout is zero
+const DefaultLockTimeout = 5 * time.Second
+
 type JSONDatabase struct {
 	filepath string
-	mu       sync.RWMutex
+	mu       sync.RWMutex // orders goroutines in this process
+	lock     *fileLock    // orders processes sharing the file
+	cipher   *Cipher      // nil stores the file in plaintext
+	codec    Codec        // used for writing; reading detects the codec
+	created  bool
+
+	// External change detection, see watch.go
+	stampMu  sync.Mutex
+	stamp    fileStamp
+	reload   ReloadFunc
+	rejected [sha256.Size]byte
+	reloaded []func() // see OnReload
 }
 
-func NewJSONDatabase(filepath string) *JSONDatabase {
-	return &JSONDatabase{
-		filepath: filepath,
-	}
+// Options configures how Open prepares the database file
+type Options struct {
+	// MustExist makes Open fail instead of creating a missing file
+	MustExist bool
+	// LockTimeout bounds how long a transaction waits for another process
+	// to release the file lock before failing with ErrLockTimeout
+	LockTimeout time.Duration
+	// Cipher, when set, encrypts the file at rest. A plaintext file or one
+	// encrypted under an old key is re-encrypted under the current key.
+	Cipher *Cipher
+	// Codec is the file format new content is written in; nil is indented
+	// JSON. Files in any other codec are still read and are converted by
+	// the next write.
+	Codec Codec
 }
 
-// ReadData reads and unmarshals JSON data from file
-func (db *JSONDatabase) ReadData(v interface{}) error {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+// Open prepares the database at path: a missing file is created with no
+// collections, a corrupt one is recovered from its .bak generation, an
+// older schema is migrated to CurrentSchemaVersion, and anything that
+// cannot be fixed, including a wrong encryption key, is returned as an
+// error. A nil opts uses the defaults.
+func Open(path string, opts *Options) (*JSONDatabase, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	timeout := opts.LockTimeout
+	if timeout <= 0 {
+		timeout = DefaultLockTimeout
+	}
+
+	lock, err := openFileLock(path+".lock", timeout)
+	if err != nil {
+		return nil, err
+	}
+	codec := opts.Codec
+	if codec == nil {
+		codec = jsonCodec{indent: true}
+	}
+	db := &JSONDatabase{filepath: path, lock: lock, cipher: opts.Cipher, codec: codec}
+
+	// Another instance may be creating or recovering the same file
+	if err := lock.lock(); err != nil {
+		lock.close()
+		return nil, err
+	}
+	defer lock.unlock()
+
+	if err := db.prepare(opts); err != nil {
+		lock.close()
+		return nil, err
+	}
+
+	return db, nil
+}
 
-	// Check if file exists
+// prepare creates or validates the file; callers must hold the file lock
+func (db *JSONDatabase) prepare(opts *Options) error {
 	if _, err := os.Stat(db.filepath); os.IsNotExist(err) {
-		// Create empty file with empty array
-		return db.WriteData([]interface{}{})
+		if opts.MustExist {
+			return fmt.Errorf("database: %s does not exist", db.filepath)
+		}
+		data, err := encode(db.codec, db.cipher, document{})
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(db.filepath, data); err != nil {
+			return fmt.Errorf("database: create %s: %w", db.filepath, err)
+		}
+		db.created = true
+		db.remember(data)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := db.recoverFromBackup(); err != nil {
+		return err
+	}
+	if err := db.migrateFile(); err != nil {
+		return err
+	}
+	if err := db.encryptFile(); err != nil {
+		return err
 	}
 
 	data, err := ioutil.ReadFile(db.filepath)
 	if err != nil {
 		return err
 	}
+	db.remember(data)
+
+	return nil
+}
 
-	// If file is empty, initialize with empty array
-	if len(data) == 0 {
-		data = []byte("[]")
+// Close releases the lock file
+func (db *JSONDatabase) Close() error {
+	return db.lock.close()
+}
+
+// Created reports whether Open created a new, empty store
+func (db *JSONDatabase) Created() bool {
+	return db.created
+}
+
+// Path returns the location of the database file
+func (db *JSONDatabase) Path() string {
+	return db.filepath
+}
+
+// Tx gives access to the collections while View or Update holds the lock.
+// A Tx is bound to one collection; Collection returns a sibling bound to
+// another one inside the same transaction.
+type Tx struct {
+	db       *JSONDatabase
+	writable bool
+	state    *txState // shared with siblings
+	name     string
+}
+
+// txState is what all Tx values of one transaction share
+type txState struct {
+	doc      document // loaded on first use
+	dirty    bool
+	onCommit []func()
+}
+
+func newTx(db *JSONDatabase, writable bool, name string) *Tx {
+	return &Tx{db: db, writable: writable, state: &txState{}, name: name}
+}
+
+// View runs fn with a read lock held for the whole call
+func (db *JSONDatabase) View(fn func(tx *Tx) error) error {
+	return db.view("", fn)
+}
+
+func (db *JSONDatabase) view(name string, fn func(tx *Tx) error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if err := db.lock.lockShared(); err != nil {
+		return err
 	}
+	defer db.lock.unlockShared()
+
+	return fn(newTx(db, false, name))
+}
 
-	return json.Unmarshal(data, v)
+// Update runs fn with the write lock held, so a read-modify-write
+// sequence inside fn cannot interleave with other writers, in this or any
+// other process. Writes are saved in one file write after fn returns nil
+// and dropped if it returns an error. When the file is being watched,
+// external changes are reloaded before fn runs.
+func (db *JSONDatabase) Update(fn func(tx *Tx) error) error {
+	return db.update("", fn)
 }
 
-// WriteData marshals and writes data to JSON file
-func (db *JSONDatabase) WriteData(v interface{}) error {
+func (db *JSONDatabase) update(name string, fn func(tx *Tx) error) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	data, err := json.MarshalIndent(v, "", "  ")
+	if err := db.lock.lock(); err != nil {
+		return err
+	}
+	defer db.lock.unlock()
+
+	db.syncExternal()
+
+	tx := newTx(db, true, name)
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if tx.state.dirty {
+		if err := db.write(tx.state.doc); err != nil {
+			return err
+		}
+	}
+	for _, f := range tx.state.onCommit {
+		f()
+	}
+	return nil
+}
+
+// Collection returns a Tx for another collection in the same transaction
+func (tx *Tx) Collection(name string) *Tx {
+	return &Tx{db: tx.db, writable: tx.writable, state: tx.state, name: name}
+}
+
+// OnCommit registers f to run after the transaction was saved, still
+// under the lock. Callers use it to update in-memory state only once the
+// write is on disk.
+func (tx *Tx) OnCommit(f func()) {
+	tx.state.onCommit = append(tx.state.onCommit, f)
+}
+
+// Collections lists the names of all stored collections
+func (tx *Tx) Collections() ([]string, error) {
+	doc, err := tx.load()
+	if err != nil {
+		return nil, err
+	}
+	return doc.names(), nil
+}
+
+// Read unmarshals the collection into v. A collection that was never
+// written leaves v untouched.
+func (tx *Tx) Read(v interface{}) error {
+	if tx.name == "" {
+		return ErrNoCollection
+	}
+
+	doc, err := tx.load()
+	if err != nil {
+		return err
+	}
+	raw, ok := doc[tx.name]
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// Write replaces the collection with v (only inside Update). The file is
+// saved when the transaction commits.
+func (tx *Tx) Write(v interface{}) error {
+	if !tx.writable {
+		return ErrReadOnlyTx
+	}
+	if tx.name == "" {
+		return ErrNoCollection
+	}
+
+	doc, err := tx.load()
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(v)
 	if err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(db.filepath, data, 0644)
+	doc[tx.name] = raw
+	tx.state.dirty = true
+	return nil
+}
+
+// Drop removes the collection (only inside Update)
+func (tx *Tx) Drop() error {
+	if !tx.writable {
+		return ErrReadOnlyTx
+	}
+	if tx.name == "" {
+		return ErrNoCollection
+	}
+
+	doc, err := tx.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := doc[tx.name]; ok {
+		delete(doc, tx.name)
+		tx.state.dirty = true
+	}
+	return nil
+}
+
+// load reads the document once per transaction
+func (tx *Tx) load() (document, error) {
+	if tx.state.doc == nil {
+		doc, err := tx.db.read()
+		if err != nil {
+			return nil, err
+		}
+		tx.state.doc = doc
+	}
+	return tx.state.doc, nil
+}
+
+// read loads the file without locking; callers must hold db.mu
+func (db *JSONDatabase) read() (document, error) {
+	data, err := ioutil.ReadFile(db.filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := db.decodeDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	db.remember(data)
+	return doc, nil
+}
+
+// decodeDocument decodes file content in any codec, encryption and schema
+// version. An older file (e.g. restored from git) is upgraded in memory;
+// the next write stores it in the current format.
+func (db *JSONDatabase) decodeDocument(data []byte) (document, error) {
+	version, doc, err := decode(db.cipher, data)
+	if err != nil {
+		return nil, err
+	}
+	if version != CurrentSchemaVersion {
+		if doc, err = migrate(doc, version, nil); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// write saves doc atomically, keeping the old file as .bak. Callers must
+// hold db.mu for writing.
+func (db *JSONDatabase) write(doc document) error {
+	data, err := encode(db.codec, db.cipher, doc)
+	if err != nil {
+		return err
+	}
+
+	if err := db.keepBackup(); err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(db.filepath, data); err != nil {
+		return err
+	}
+	db.remember(data)
+	return nil
 }
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// backupPath is where the previous generation of the file is kept
+func (db *JSONDatabase) backupPath() string {
+	return db.filepath + ".bak"
+}
+
+// renameFile is os.Rename, replaced in tests to make the last step of
+// writeFileAtomic fail
+var renameFile = os.Rename
+
+// writeFileAtomic writes data to a temp file in the same directory,
+// fsyncs it and renames it over path, so readers only ever see the old
+// or the new content, never a truncated file
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// Clean up the temp file if anything below fails
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	if err := renameFile(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// syncDir flushes the directory entry so a rename survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	// Some platforms cannot fsync a directory; the rename is still atomic there
+	d.Sync()
+	return nil
+}
+
+// keepBackup preserves the current file as the .bak generation before it
+// gets replaced. A hard link is free; fall back to a copy when the
+// filesystem does not support links.
+func (db *JSONDatabase) keepBackup() error {
+	if _, err := os.Stat(db.filepath); os.IsNotExist(err) {
+		return nil
+	}
+
+	bak := db.backupPath()
+	if err := os.Remove(bak); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(db.filepath, bak); err == nil {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(db.filepath)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(bak, data)
+}
+
+// recoverFromBackup checks that the file parses and, if it does not, restores the
+// previous generation from the .bak file. Open runs it before the
+// database is shared, so no locking is needed.
+func (db *JSONDatabase) recoverFromBackup() error {
+	data, err := ioutil.ReadFile(db.filepath)
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 && parses(data) {
+		return nil
+	}
+
+	backup, err := ioutil.ReadFile(db.backupPath())
+	if err != nil || !parses(backup) {
+		if len(data) == 0 {
+			// An empty file with nothing to fall back to is a fresh store
+			return nil
+		}
+		return fmt.Errorf("database: %s is corrupt and no usable backup exists", db.filepath)
+	}
+
+	log.Printf("WARNING: %s is corrupt, restoring previous generation from %s", db.filepath, db.backupPath())
+	return writeFileAtomic(db.filepath, backup)
+}
+
+// parses reports whether data is a readable database file in any codec.
+// Encrypted content only has its envelope checked, so a wrong key is
+// reported as such later instead of being mistaken for corruption.
+func parses(data []byte) bool {
+	if _, ok := parseSealed(data); ok {
+		return true
+	}
+	_, _, err := decodeAny(data)
+	return err == nil
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package database
+
+import "os"
+
+// Without flock the lock only protects a single process; the in-process
+// mutex in JSONDatabase still applies
+
+func tryFlock(file *os.File, exclusive bool) (ok bool, err error) {
+	return true, nil
+}
+
+func funlock(file *os.File) error {
+	return nil
+}
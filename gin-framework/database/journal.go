@@ -0,0 +1,236 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+)
+
+// Journal is an append-only file of JSON values, one per line, kept next
+// to the database file and encrypted like it, line by line. Unlike a Log
+// it is shared by every process that opens the database: appends take an
+// exclusive lock on a sidecar lock file, and every call first reads what
+// other processes appended since the previous one, so all of them see the
+// same lines in the same order.
+type Journal struct {
+	path   string
+	lock   *fileLock
+	cipher *Cipher
+
+	mu     sync.Mutex
+	offset int64 // how far the file has been read
+}
+
+// OpenJournal opens or creates the journal at path with the cipher and
+// lock timeout of db. Lines written under an old key are re-encrypted
+// under the current one.
+func (db *JSONDatabase) OpenJournal(path string) (*Journal, error) {
+	lock, err := openFileLock(path+".lock", db.lock.timeout)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{path: path, lock: lock, cipher: db.cipher}
+
+	if err := j.rekey(); err != nil {
+		lock.close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// View calls seen with each line appended since the previous call, by
+// this or any other process, oldest first
+func (j *Journal) View(seen func(raw json.RawMessage) error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.lock.lockShared(); err != nil {
+		return err
+	}
+	defer j.lock.unlockShared()
+
+	_, err := j.catchUp(seen)
+	return err
+}
+
+// Update calls seen like View and then durably appends the values add
+// returns, all under the exclusive lock, so the new lines can build on
+// every line before them
+func (j *Journal) Update(seen func(raw json.RawMessage) error, add func() ([]interface{}, error)) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.lock.lock(); err != nil {
+		return err
+	}
+	defer j.lock.unlock()
+
+	torn, err := j.catchUp(seen)
+	if err != nil {
+		return err
+	}
+	values, err := add()
+	if err != nil || len(values) == 0 {
+		return err
+	}
+
+	var lines bytes.Buffer
+	for _, v := range values {
+		line, err := j.encode(v)
+		if err != nil {
+			return err
+		}
+		lines.Write(line)
+	}
+
+	file, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if torn {
+		// Nobody else can be appending; the rest is a crashed append
+		log.Printf("WARNING: dropping incomplete last line of %s", j.path)
+		if err := file.Truncate(j.offset); err != nil {
+			return err
+		}
+	}
+	if _, err := file.WriteAt(lines.Bytes(), j.offset); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	j.offset += int64(lines.Len())
+	return nil
+}
+
+// catchUp reads the complete lines after j.offset and reports whether an
+// incomplete one follows them. Callers must hold j.mu and the lock.
+func (j *Journal) catchUp(seen func(raw json.RawMessage) error) (torn bool, err error) {
+	file, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(j.offset, io.SeekStart); err != nil {
+		return false, err
+	}
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// A line without its newline is an append still being written
+			// or one torn by a crash
+			return len(line) > 0, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		raw, _, err := j.decode(line)
+		if errors.Is(err, ErrWrongKey) {
+			return false, fmt.Errorf("%s: %w", j.path, err)
+		}
+		if err != nil {
+			return false, fmt.Errorf("database: corrupt line in %s at byte %d: %w", j.path, j.offset, err)
+		}
+		if err := seen(raw); err != nil {
+			return false, err
+		}
+		j.offset += int64(len(line))
+	}
+}
+
+// rekey rewrites the journal when any line is not under the current key
+func (j *Journal) rekey() error {
+	if j.cipher == nil {
+		return nil
+	}
+	if err := j.lock.lock(); err != nil {
+		return err
+	}
+	defer j.lock.unlock()
+
+	data, err := ioutil.ReadFile(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	stale := 0
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(line) == 0 || line[len(line)-1] != '\n' {
+			// Left for the next Update to drop
+			out.Write(line)
+			continue
+		}
+		raw, keyID, err := j.decode(line)
+		if err != nil {
+			return fmt.Errorf("%s: %w", j.path, err)
+		}
+		if keyID == j.cipher.current {
+			out.Write(line)
+			continue
+		}
+		if line, err = j.encode(raw); err != nil {
+			return err
+		}
+		out.Write(line)
+		stale++
+	}
+	if stale == 0 {
+		return nil
+	}
+
+	if err := writeFileAtomic(j.path, out.Bytes()); err != nil {
+		return err
+	}
+	log.Printf("Re-encrypted %d line(s) of %s under key %s", stale, j.path, j.cipher.current)
+	return nil
+}
+
+// encode renders v as one line, encrypted when the journal has a Cipher
+func (j *Journal) encode(v interface{}) ([]byte, error) {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if line, err = j.cipher.encrypt(line); err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// decode parses one line written by encode
+func (j *Journal) decode(line []byte) (json.RawMessage, string, error) {
+	plain, keyID, err := j.cipher.decrypt(line)
+	if err != nil {
+		return nil, keyID, err
+	}
+	plain = bytes.TrimSpace(plain)
+	if !json.Valid(plain) {
+		return nil, keyID, errInvalidJSON
+	}
+	return json.RawMessage(plain), keyID, nil
+}
+
+// Close releases the lock file
+func (j *Journal) Close() error {
+	return j.lock.close()
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrLockTimeout is returned when another process holds the file lock for
+// longer than the configured timeout
+var ErrLockTimeout = errors.New("database: timed out waiting for file lock")
+
+// lockRetryInterval is how often a busy lock is retried until the timeout
+const lockRetryInterval = 10 * time.Millisecond
+
+// fileLock is an advisory OS-level lock on a sidecar file, shared by all
+// processes that open the same database. The data file itself cannot be
+// locked because every write replaces it with a new inode.
+//
+// flock belongs to the open file, not to a goroutine, so concurrent
+// readers in this process share one shared lock: the first takes it and
+// the last releases it.
+type fileLock struct {
+	file    *os.File
+	timeout time.Duration
+
+	mu      sync.Mutex
+	readers int
+}
+
+func openFileLock(path string, timeout time.Duration) (*fileLock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileLock{file: file, timeout: timeout}, nil
+}
+
+// lockShared takes the lock for reading
+func (l *fileLock) lockShared() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.readers == 0 {
+		if err := l.acquire(false); err != nil {
+			return err
+		}
+	}
+	l.readers++
+	return nil
+}
+
+func (l *fileLock) unlockShared() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.readers--
+	if l.readers == 0 {
+		return funlock(l.file)
+	}
+	return nil
+}
+
+// lock takes the lock for writing. The database write mutex guarantees no
+// reader in this process holds the shared lock at the same time.
+func (l *fileLock) lock() error {
+	return l.acquire(true)
+}
+
+func (l *fileLock) unlock() error {
+	return funlock(l.file)
+}
+
+// acquire retries a non-blocking flock until it succeeds or the timeout
+// passes
+func (l *fileLock) acquire(exclusive bool) error {
+	deadline := time.Now().Add(l.timeout)
+	for {
+		ok, err := tryFlock(l.file, exclusive)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrLockTimeout
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+func (l *fileLock) close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,233 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+)
+
+// migration upgrades a document from version-1 to version. up changes the
+// collections in place and must not touch anything else.
+type migration struct {
+	version     int
+	description string
+	up          func(doc document) error
+}
+
+// migrations is the ordered registry of schema upgrades. To change the
+// stored format append a new entry with the next version number; never
+// edit or reorder one that has shipped.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "add the schema_version header around the collections",
+		up:          func(doc document) error { return nil },
+	},
+	{
+		version:     2,
+		description: "seed the persisted task ID sequence from the highest task ID",
+		up:          seedTaskSequence,
+	},
+	{
+		version:     3,
+		description: "start every task at version 1 for optimistic concurrency",
+		up:          setTaskVersions,
+	},
+}
+
+// CurrentSchemaVersion is the version this build writes
+var CurrentSchemaVersion = migrations[len(migrations)-1].version
+
+// migrate upgrades doc from version to CurrentSchemaVersion. report, when
+// not nil, is called after each step with the document before and after.
+func migrate(doc document, version int, report func(m migration, before, after document)) (document, error) {
+	if version > CurrentSchemaVersion {
+		return nil, fmt.Errorf("database: schema version %d is newer than the supported version %d", version, CurrentSchemaVersion)
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+
+		before := doc.clone()
+		if err := m.up(doc); err != nil {
+			return nil, fmt.Errorf("database: migration to version %d (%s): %w", m.version, m.description, err)
+		}
+		if report != nil {
+			report(m, before, doc)
+		}
+	}
+
+	return doc, nil
+}
+
+// migrateFile upgrades the file on disk if it uses an older schema. The
+// pre-migration content stays in the .bak generation. Callers must hold
+// the file lock.
+func (db *JSONDatabase) migrateFile() error {
+	data, err := ioutil.ReadFile(db.filepath)
+	if err != nil {
+		return err
+	}
+	version, doc, err := decode(db.cipher, data)
+	if err != nil {
+		return err
+	}
+	if version == CurrentSchemaVersion {
+		return nil
+	}
+
+	doc, err = migrate(doc, version, func(m migration, before, after document) {
+		log.Printf("Migrated %s to schema version %d: %s", db.filepath, m.version, m.description)
+	})
+	if err != nil {
+		return err
+	}
+	return db.write(doc)
+}
+
+// MigrationStep describes one pending migration and what it would change
+type MigrationStep struct {
+	Version     int
+	Description string
+	Changes     []string
+}
+
+// PlanMigrations reports the migrations Open would run on the file at
+// path, without writing anything. c decrypts an encrypted file and may be
+// nil for a plaintext one.
+func PlanMigrations(path string, c *Cipher) (from int, steps []MigrationStep, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	from, doc, err := decode(c, data)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	_, err = migrate(doc, from, func(m migration, before, after document) {
+		steps = append(steps, MigrationStep{
+			Version:     m.version,
+			Description: m.description,
+			Changes:     diffDocuments(before, after),
+		})
+	})
+	return from, steps, err
+}
+
+// seedTaskSequence stores the highest numeric task ID in the "sequences"
+// collection, so IDs issued from now on are never reused
+func seedTaskSequence(doc document) error {
+	var tasks []struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if raw, ok := doc["tasks"]; ok {
+		if err := json.Unmarshal(raw, &tasks); err != nil {
+			return err
+		}
+	}
+
+	sequences := map[string]uint64{}
+	if raw, ok := doc["sequences"]; ok {
+		if err := json.Unmarshal(raw, &sequences); err != nil {
+			return err
+		}
+	}
+	for _, task := range tasks {
+		if n, err := strconv.ParseUint(string(task.ID), 10, 64); err == nil && n > sequences["tasks"] {
+			sequences["tasks"] = n
+		}
+	}
+
+	raw, err := json.Marshal(sequences)
+	if err != nil {
+		return err
+	}
+	doc["sequences"] = raw
+	return nil
+}
+
+// setTaskVersions gives each task without a version field version 1
+func setTaskVersions(doc document) error {
+	raw, ok := doc["tasks"]
+	if !ok {
+		return nil
+	}
+
+	var tasks []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &tasks); err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		if _, ok := task["version"]; !ok {
+			task["version"] = json.RawMessage("1")
+		}
+	}
+
+	raw, err := json.Marshal(tasks)
+	if err != nil {
+		return err
+	}
+	doc["tasks"] = raw
+	return nil
+}
+
+func (doc document) clone() document {
+	copied := make(document, len(doc))
+	for name, raw := range doc {
+		copied[name] = append(json.RawMessage(nil), raw...)
+	}
+	return copied
+}
+
+// diffDocuments summarizes per collection what changed between two
+// versions of a document
+func diffDocuments(before, after document) []string {
+	var changes []string
+
+	for _, name := range before.names() {
+		if _, ok := after[name]; !ok {
+			changes = append(changes, fmt.Sprintf("collection %q removed", name))
+		}
+	}
+	for _, name := range after.names() {
+		old, ok := before[name]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("collection %q added", name))
+			continue
+		}
+		if bytes.Equal(compactJSON(old), compactJSON(after[name])) {
+			continue
+		}
+
+		var oldItems, newItems []json.RawMessage
+		if json.Unmarshal(old, &oldItems) != nil || json.Unmarshal(after[name], &newItems) != nil {
+			changes = append(changes, fmt.Sprintf("collection %q changed", name))
+			continue
+		}
+
+		changed := 0
+		for i := 0; i < len(oldItems) && i < len(newItems); i++ {
+			if !bytes.Equal(compactJSON(oldItems[i]), compactJSON(newItems[i])) {
+				changed++
+			}
+		}
+		changes = append(changes, fmt.Sprintf("collection %q: %d of %d items changed, %d -> %d items",
+			name, changed, len(oldItems), len(oldItems), len(newItems)))
+	}
+
+	return changes
+}
+
+func compactJSON(raw json.RawMessage) []byte {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return raw
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,11 @@
+//go:build unix
+
+package database
+
+import "os"
+
+// pinFile opens path; the open file keeps the current generation after a
+// new one is renamed over path. Callers must hold db.mu.
+func pinFile(path string) (pinnedFile, error) {
+	return os.Open(path)
+}
@@ -0,0 +1,246 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// errNotStreamable makes Scan fall back to decoding the whole file
+var errNotStreamable = errors.New("database: file cannot be streamed")
+
+// Scan calls fn with the raw JSON of each element of the collection, which
+// must hold an array, in stored order until fn returns false. Unless the
+// transaction already loaded the file, elements are decoded token by
+// token straight from disk, so memory use is bounded by the largest
+// element instead of the file size. Plaintext JSON files, gzipped or not,
+// are streamed; encrypted, gob and older-schema files are decoded in full
+// first.
+func (tx *Tx) Scan(fn func(raw json.RawMessage) bool) error {
+	if tx.name == "" {
+		return ErrNoCollection
+	}
+	if tx.state.doc == nil {
+		f, err := os.Open(tx.db.filepath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		err = streamCollection(f, tx.name, fn)
+		if err != errNotStreamable {
+			return err
+		}
+	}
+
+	doc, err := tx.load()
+	if err != nil {
+		return err
+	}
+	return scanDocument(doc, tx.name, fn)
+}
+
+// Scan runs PinnedCollection.Scan once. The lock is only held to pin the
+// file, so fn may block, e.g. on a slow client, without holding up writers.
+func (c *Collection) Scan(fn func(raw json.RawMessage) bool) error {
+	p, err := c.Pin()
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	return p.Scan(fn)
+}
+
+// PinnedCollection is a collection as it was when Pin was called. Reading
+// it needs no lock: the file is only ever replaced by renaming a new one
+// over it, so the pinned file keeps its content while later transactions
+// commit.
+type PinnedCollection struct {
+	db   *JSONDatabase
+	name string
+	file pinnedFile
+	doc  document // decoded on first use when the file cannot be streamed
+}
+
+// pinnedFile is file content that stays the same after the lock is
+// released, see pinFile
+type pinnedFile interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// Pin takes a consistent view of the collection for one or more Scans;
+// Close releases it
+func (c *Collection) Pin() (*PinnedCollection, error) {
+	var p *PinnedCollection
+	err := c.View(func(tx *Tx) error {
+		f, err := pinFile(c.db.filepath)
+		if err != nil {
+			return err
+		}
+		p = &PinnedCollection{db: c.db, name: c.name, file: f}
+		return nil
+	})
+	return p, err
+}
+
+// Scan is Tx.Scan over the pinned content; it can be called repeatedly
+// and sees the same elements each time
+func (p *PinnedCollection) Scan(fn func(raw json.RawMessage) bool) error {
+	if p.doc == nil {
+		if _, err := p.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		err := streamCollection(p.file, p.name, fn)
+		if err != errNotStreamable {
+			return err
+		}
+
+		if _, err := p.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(p.file)
+		if err != nil {
+			return err
+		}
+		if p.doc, err = p.db.decodeDocument(data); err != nil {
+			return err
+		}
+	}
+	return scanDocument(p.doc, p.name, fn)
+}
+
+// Close releases the pinned file
+func (p *PinnedCollection) Close() error {
+	return p.file.Close()
+}
+
+// scanDocument scans the named collection of an already decoded document
+func scanDocument(doc document, name string, fn func(raw json.RawMessage) bool) error {
+	raw, ok := doc[name]
+	if !ok {
+		return nil
+	}
+	return scanArray(json.NewDecoder(bytes.NewReader(raw)), fn)
+}
+
+// streamCollection reads the collection called name from file content
+// with a streaming decoder. It returns errNotStreamable before calling fn
+// when the content has to be decoded in full.
+func streamCollection(r io.Reader, name string, fn func(raw json.RawMessage) bool) error {
+	br := bufio.NewReader(r)
+	r = br
+	if magic, _ := br.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return errNotStreamable
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	dec := json.NewDecoder(r)
+	found, err := seekCollection(dec, name)
+	if err != nil || !found {
+		return err
+	}
+	return scanArray(dec, fn)
+}
+
+// seekCollection advances dec to the value of the named collection and
+// reports whether it exists. Only the current schema header layout with
+// schema_version first, as every codec writes it, is streamed; anything
+// else, including an encryption envelope, is errNotStreamable.
+func seekCollection(dec *json.Decoder, name string) (bool, error) {
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return false, errNotStreamable
+	}
+	if key, err := dec.Token(); err != nil || key != "schema_version" {
+		return false, errNotStreamable
+	}
+	if version, err := dec.Token(); err != nil || version != float64(CurrentSchemaVersion) {
+		return false, errNotStreamable
+	}
+
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return false, err
+		}
+		if key != "collections" {
+			if err := skipValue(dec); err != nil {
+				return false, err
+			}
+			continue
+		}
+
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+			return false, errInvalidJSON
+		}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return false, err
+			}
+			if key == name {
+				return true, nil
+			}
+			if err := skipValue(dec); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+	return false, nil
+}
+
+// skipValue consumes the next value token by token without keeping it
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// scanArray calls fn for each element of the array dec is positioned at;
+// null is an empty array
+func scanArray(dec *json.Decoder, fn func(raw json.RawMessage) bool) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if tok != json.Delim('[') {
+		return errors.New("database: collection is not an array")
+	}
+
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		if !fn(raw) {
+			return nil
+		}
+	}
+	return nil
+}
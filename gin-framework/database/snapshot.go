@@ -0,0 +1,177 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// ErrSnapshotNotFound is returned when no snapshot has the requested name
+var ErrSnapshotNotFound = errors.New("database: snapshot not found")
+
+// snapshotTimeFormat sorts lexically in time order and is safe in file
+// names
+const snapshotTimeFormat = "20060102T150405.000Z"
+
+// snapshotName matches "<label>-<time>.json", e.g.
+// "scheduled-20260125T103000.000Z.json"
+var snapshotName = regexp.MustCompile(`^([a-z0-9_]+)-(\d{8}T\d{6}\.\d{3}Z)\.json$`)
+
+// snapshotLabel is what may precede the time in a snapshot name
+var snapshotLabel = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// SnapshotInfo describes one snapshot file
+type SnapshotInfo struct {
+	Name      string    `json:"name"`
+	Label     string    `json:"label"`
+	CreatedAt time.Time `json:"created_at"`
+	Size      int64     `json:"size"`
+}
+
+// Snapshot copies the file into dir as "<label>-<time>.json" under the
+// read lock, so the copy is a consistent point-in-time state even while
+// other goroutines or processes write. An encrypted file stays encrypted.
+func (db *JSONDatabase) Snapshot(dir, label string) (*SnapshotInfo, error) {
+	if !snapshotLabel.MatchString(label) {
+		return nil, fmt.Errorf("database: invalid snapshot label %q", label)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	var info *SnapshotInfo
+	err := db.View(func(tx *Tx) error {
+		data, err := ioutil.ReadFile(db.filepath)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		name := label + "-" + now.Format(snapshotTimeFormat) + ".json"
+		if err := writeFileAtomic(filepath.Join(dir, name), data); err != nil {
+			return err
+		}
+
+		info = &SnapshotInfo{Name: name, Label: label, CreatedAt: now.Truncate(time.Millisecond), Size: int64(len(data))}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// ListSnapshots returns the snapshots in dir newest first. Other files in
+// dir are ignored; a missing dir has no snapshots.
+func ListSnapshots(dir string) ([]SnapshotInfo, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []SnapshotInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []SnapshotInfo{}
+	for _, entry := range entries {
+		m := snapshotName.FindStringSubmatch(entry.Name())
+		if m == nil || entry.IsDir() {
+			continue
+		}
+		created, err := time.Parse(snapshotTimeFormat, m[2])
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, SnapshotInfo{
+			Name:      entry.Name(),
+			Label:     m[1],
+			CreatedAt: created,
+			Size:      entry.Size(),
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// Restore replaces the database content with the snapshot called name in
+// dir. The snapshot is checked first: it must decrypt with the configured
+// keys and use a schema this build understands. The replaced content is
+// kept as the .bak generation. reload, when not nil, runs under the same
+// lock so in-memory state follows the restored file.
+func (db *JSONDatabase) Restore(dir, name string, reload ReloadFunc) error {
+	// The name comes from users; never let it leave dir
+	if !snapshotName.MatchString(name) {
+		return ErrSnapshotNotFound
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return ErrSnapshotNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	version, doc, err := decode(db.cipher, data)
+	if err != nil {
+		return fmt.Errorf("database: snapshot %s: %w", name, err)
+	}
+	if doc, err = migrate(doc, version, nil); err != nil {
+		return fmt.Errorf("database: snapshot %s: %w", name, err)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.lock.lock(); err != nil {
+		return err
+	}
+	defer db.lock.unlock()
+
+	if err := db.write(doc); err != nil {
+		return err
+	}
+	log.Printf("Restored %s from snapshot %s", db.filepath, name)
+
+	if reload != nil {
+		if err := reload(newTx(db, false, "")); err != nil {
+			return err
+		}
+	}
+	db.notifyReloaded()
+	return nil
+}
+
+// PruneSnapshots deletes the snapshots with the given label in dir except
+// the newest keep, and returns the names it deleted
+func PruneSnapshots(dir, label string, keep int) ([]string, error) {
+	snapshots, err := ListSnapshots(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	kept := 0
+	for _, s := range snapshots {
+		if s.Label != label {
+			continue
+		}
+		if kept < keep {
+			kept++
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, s.Name)); err != nil {
+			return removed, err
+		}
+		removed = append(removed, s.Name)
+	}
+	return removed, nil
+}
@@ -0,0 +1,266 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+)
+
+// Log operations
+const (
+	OpPut    = "put"
+	OpDelete = "delete"
+)
+
+// Record is one mutation in the write-ahead log. Put records carry the
+// full new value in Data, delete records only the Key.
+type Record struct {
+	Seq  uint64          `json:"seq"`
+	Op   string          `json:"op"`
+	Key  string          `json:"key"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Log is an append-only file of JSON records, one per line. Every append
+// is fsynced before it returns, so an acknowledged write survives a crash.
+// With a Cipher each line is encrypted on its own.
+type Log struct {
+	path   string
+	lock   *fileLock
+	cipher *Cipher
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	seq    uint64
+}
+
+// OpenLog opens or creates the log at path and positions it for appending.
+// The log is replayed into one process's memory, so it cannot be shared:
+// a second process opening it fails right away. c may be nil to write
+// plaintext records.
+func OpenLog(path string, c *Cipher) (*Log, error) {
+	lock, err := openFileLock(path+".lock", 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := lock.lock(); err != nil {
+		lock.close()
+		if errors.Is(err, ErrLockTimeout) {
+			return nil, fmt.Errorf("database: %s is in use by another process", path)
+		}
+		return nil, err
+	}
+
+	l := &Log{path: path, lock: lock, cipher: c}
+
+	// Read through once to learn the last sequence number and drop a
+	// record torn by a crash in the middle of an append
+	if err := l.Replay(func(rec Record) error { return nil }); err != nil {
+		lock.close()
+		return nil, err
+	}
+	if err := l.open(); err != nil {
+		lock.close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// Append assigns the next sequence number to rec and writes it durably
+func (l *Log) Append(rec *Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rec.Seq = l.seq + 1
+	line, err := l.encode(*rec)
+	if err != nil {
+		return err
+	}
+
+	if _, err := l.file.Write(line); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+
+	l.seq = rec.Seq
+	l.size += int64(len(line))
+	return nil
+}
+
+// Replay calls fn for every record in the log, oldest first. A partial
+// last line (a crash mid-append) is cut off; corruption anywhere else is
+// reported as an error.
+func (l *Log) Replay(fn func(rec Record) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	data, err := ioutil.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var valid int
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		rec, err := l.decode(line)
+		if errors.Is(err, ErrWrongKey) {
+			return fmt.Errorf("%s: %w", l.path, err)
+		}
+		if err != nil {
+			// Only a last line without its newline can be a torn append
+			if valid+len(line) != len(data) {
+				return fmt.Errorf("database: corrupt record in %s at byte %d: %w", l.path, valid, err)
+			}
+			log.Printf("WARNING: dropping incomplete last record of %s", l.path)
+			return l.truncate(int64(valid))
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+		if rec.Seq > l.seq {
+			l.seq = rec.Seq
+		}
+		valid += len(line) + 1
+	}
+
+	return scanner.Err()
+}
+
+// encode renders rec as one log line, encrypted when the log has a Cipher
+func (l *Log) encode(rec Record) ([]byte, error) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	if line, err = l.cipher.encrypt(line); err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// decode parses one log line written by encode
+func (l *Log) decode(line []byte) (Record, error) {
+	var rec Record
+	plain, _, err := l.cipher.decrypt(line)
+	if err != nil {
+		return rec, err
+	}
+	err = json.Unmarshal(plain, &rec)
+	return rec, err
+}
+
+// truncate cuts the log at size; callers must hold l.mu
+func (l *Log) truncate(size int64) error {
+	if err := os.Truncate(l.path, size); err != nil {
+		return err
+	}
+	l.size = size
+	return nil
+}
+
+// Seq returns the sequence number of the last appended record
+func (l *Log) Seq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq
+}
+
+// Size returns the current size of the log file in bytes
+func (l *Log) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.size
+}
+
+// TruncateThrough drops every record up to and including seq, once they
+// are covered by a snapshot. Later records are kept in order.
+func (l *Log) TruncateThrough(seq uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	data, err := ioutil.ReadFile(l.path)
+	if err != nil {
+		return err
+	}
+
+	var keep bytes.Buffer
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		rec, err := l.decode(line)
+		if errors.Is(err, ErrWrongKey) {
+			return fmt.Errorf("%s: %w", l.path, err)
+		}
+		if err != nil {
+			// As in Replay, only a last line without its newline can be
+			// a torn append; anything else must not be dropped silently
+			if i != len(lines)-1 || bytes.HasSuffix(line, []byte("\n")) {
+				return fmt.Errorf("database: corrupt record in %s: %w", l.path, err)
+			}
+			log.Printf("WARNING: dropping incomplete last record of %s", l.path)
+			continue
+		}
+		if rec.Seq <= seq {
+			continue
+		}
+		// Kept records move to the current key
+		line, err := l.encode(rec)
+		if err != nil {
+			return err
+		}
+		keep.Write(line)
+	}
+
+	// Reopen whether or not the rewrite went through, so a failed write
+	// (a full disk, say) leaves the old log in place and appendable
+	err = l.file.Close()
+	if err == nil {
+		err = writeFileAtomic(l.path, keep.Bytes())
+	}
+	if openErr := l.open(); err == nil {
+		err = openErr
+	}
+	return err
+}
+
+// Close closes the underlying file and releases the lock
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.file.Close()
+	l.lock.close()
+	return err
+}
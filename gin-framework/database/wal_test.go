@@ -0,0 +1,249 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeLog creates a log at path under c holding one put per key
+func writeLog(t *testing.T, path string, c *Cipher, keys ...string) {
+	t.Helper()
+	l, err := OpenLog(path, c)
+	if err != nil {
+		t.Fatalf("OpenLog: %v", err)
+	}
+	defer l.Close()
+	for _, key := range keys {
+		if err := l.Append(&Record{Op: OpPut, Key: key, Data: []byte(`{"title":"` + key + `"}`)}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+}
+
+// replayKeys opens the log at path under c and returns the keys of its
+// records in order
+func replayKeys(t *testing.T, path string, c *Cipher) []string {
+	t.Helper()
+	l, err := OpenLog(path, c)
+	if err != nil {
+		t.Fatalf("OpenLog: %v", err)
+	}
+	defer l.Close()
+
+	var keys []string
+	err = l.Replay(func(rec Record) error {
+		keys = append(keys, rec.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	return keys
+}
+
+func logLines(t *testing.T, path string) [][]byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	return bytes.SplitAfter(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+}
+
+func appendRaw(t *testing.T, path string, data []byte) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+}
+
+func TestLogTornEncryptedLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json.wal")
+	c := newTestCipher(t, keyA)
+	writeLog(t, path, c, "1", "2")
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Half of an encrypted record, as left by a crash mid-append
+	lines := logLines(t, path)
+	appendRaw(t, path, lines[0][:len(lines[0])/2])
+
+	if got := replayKeys(t, path, c); strings.Join(got, ",") != "1,2" {
+		t.Fatalf("replayed %v, want [1 2]", got)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() != info.Size() {
+		t.Fatalf("log is %d bytes after opening, want the torn line cut back to %d", after.Size(), info.Size())
+	}
+}
+
+func TestLogCorruptRecordIsFatal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json.wal")
+	c := newTestCipher(t, keyA)
+	writeLog(t, path, c, "1", "2")
+
+	lines := logLines(t, path)
+	corrupt := append([]byte("garbage"), lines[0]...)
+	if err := ioutil.WriteFile(path, append(corrupt, lines[1]...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if l, err := OpenLog(path, c); err == nil {
+		l.Close()
+		t.Fatal("OpenLog succeeded on a log with a corrupt record before the last one")
+	}
+}
+
+func TestLogWrongKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json.wal")
+	writeLog(t, path, newTestCipher(t, keyA), "1")
+
+	l, err := OpenLog(path, newTestCipher(t, keyB))
+	if err == nil {
+		l.Close()
+		t.Fatal("OpenLog succeeded with the wrong key")
+	}
+	if !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("OpenLog error = %v, want ErrWrongKey", err)
+	}
+}
+
+func TestLogTruncateThroughRotatedKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json.wal")
+	writeLog(t, path, newTestCipher(t, keyA), "1", "2", "3")
+
+	rotated := newTestCipher(t, keyB, keyA)
+	l, err := OpenLog(path, rotated)
+	if err != nil {
+		t.Fatalf("OpenLog with the old key among the keys: %v", err)
+	}
+	if err := l.TruncateThrough(1); err != nil {
+		t.Fatalf("TruncateThrough: %v", err)
+	}
+	// Appends after the truncation continue the sequence
+	if err := l.Append(&Record{Op: OpDelete, Key: "4"}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	l.Close()
+
+	for i, line := range logLines(t, path) {
+		s, ok := parseSealed(line)
+		if !ok || s.KeyID != KeyID(keyB) {
+			t.Errorf("line %d is not under the new key %s", i+1, KeyID(keyB))
+		}
+	}
+	if got := replayKeys(t, path, newTestCipher(t, keyB)); strings.Join(got, ",") != "2,3,4" {
+		t.Fatalf("replayed %v with the new key only, want [2 3 4]", got)
+	}
+}
+
+func TestLogTruncateThroughCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json.wal")
+	c := newTestCipher(t, keyA)
+	writeLog(t, path, c, "1", "2", "3")
+
+	l, err := OpenLog(path, c)
+	if err != nil {
+		t.Fatalf("OpenLog: %v", err)
+	}
+	defer l.Close()
+
+	lines := logLines(t, path)
+	lines[1] = []byte("garbage\n")
+	before := bytes.Join(lines, nil)
+	if err := ioutil.WriteFile(path, before, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.TruncateThrough(1); err == nil {
+		t.Fatal("TruncateThrough dropped a corrupt record without an error")
+	}
+	after, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, before) {
+		t.Fatal("TruncateThrough changed the log although it failed")
+	}
+}
+
+func TestLogTruncateThroughDropsTornLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json.wal")
+	c := newTestCipher(t, keyA)
+	writeLog(t, path, c, "1", "2")
+
+	l, err := OpenLog(path, c)
+	if err != nil {
+		t.Fatalf("OpenLog: %v", err)
+	}
+	lines := logLines(t, path)
+	appendRaw(t, path, lines[0][:10])
+
+	if err := l.TruncateThrough(1); err != nil {
+		t.Fatalf("TruncateThrough: %v", err)
+	}
+	l.Close()
+
+	if got := replayKeys(t, path, c); strings.Join(got, ",") != "2" {
+		t.Fatalf("replayed %v, want [2]", got)
+	}
+}
+
+func TestLogTruncateThroughFailedRenameKeepsAppending(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json.wal")
+	l, err := OpenLog(path, nil)
+	if err != nil {
+		t.Fatalf("OpenLog: %v", err)
+	}
+	defer l.Close()
+	for _, key := range []string{"1", "2"} {
+		if err := l.Append(&Record{Op: OpPut, Key: key, Data: []byte(`{}`)}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+
+	renameFile = func(oldpath, newpath string) error { return errors.New("no space left on device") }
+	err = l.TruncateThrough(1)
+	renameFile = os.Rename
+	if err == nil {
+		t.Fatal("TruncateThrough succeeded although the rename failed")
+	}
+
+	// The old log is still in place and takes appends
+	if err := l.Append(&Record{Op: OpDelete, Key: "3"}); err != nil {
+		t.Fatalf("Append after a failed TruncateThrough: %v", err)
+	}
+	if err := l.TruncateThrough(1); err != nil {
+		t.Fatalf("TruncateThrough: %v", err)
+	}
+	if err := l.Append(&Record{Op: OpDelete, Key: "4"}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	var keys []string
+	err = l.Replay(func(rec Record) error {
+		keys = append(keys, rec.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if strings.Join(keys, ",") != "2,3,4" {
+		t.Fatalf("replayed %v, want [2 3 4]", keys)
+	}
+}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// fileStamp identifies the file content this process last read or wrote.
+// mtime and size are cheap to poll; the checksum settles whether a touched
+// file really changed.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+	sum     [sha256.Size]byte
+}
+
+// ReloadFunc re-reads the file after it was changed by someone else.
+// Returning an error rejects the new content and keeps the current state.
+type ReloadFunc func(tx *Tx) error
+
+// remember records data as the content this process knows about
+func (db *JSONDatabase) remember(data []byte) {
+	info, err := os.Stat(db.filepath)
+	if err != nil {
+		return
+	}
+
+	db.stampMu.Lock()
+	db.stamp = fileStamp{modTime: info.ModTime(), size: info.Size(), sum: sha256.Sum256(data)}
+	db.stampMu.Unlock()
+}
+
+// changedOnDisk reports whether the file differs from the last content
+// this process read or wrote
+func (db *JSONDatabase) changedOnDisk() (bool, [sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+
+	info, err := os.Stat(db.filepath)
+	if err != nil {
+		return false, sum, err
+	}
+
+	db.stampMu.Lock()
+	stamp := db.stamp
+	db.stampMu.Unlock()
+
+	if info.ModTime().Equal(stamp.modTime) && info.Size() == stamp.size {
+		return false, sum, nil
+	}
+
+	data, err := ioutil.ReadFile(db.filepath)
+	if err != nil {
+		return false, sum, err
+	}
+	sum = sha256.Sum256(data)
+	if sum == stamp.sum {
+		// Touched but identical; just move the stamp forward
+		db.remember(data)
+		return false, sum, nil
+	}
+
+	return true, sum, nil
+}
+
+// Watch polls the file every interval and calls reload when it was
+// changed outside this process, e.g. edited by hand or restored from git.
+// Content that does not parse is rejected with a logged error and the
+// current state is kept. Update also checks for such changes before
+// running, so a write never silently overwrites an external edit.
+// The returned function stops the polling.
+func (db *JSONDatabase) Watch(interval time.Duration, reload ReloadFunc) (stop func()) {
+	db.mu.Lock()
+	db.reload = reload
+	db.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				db.poll()
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// poll runs one watch check under both locks. A busy file lock just
+// skips this round.
+func (db *JSONDatabase) poll() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.lock.lockShared(); err != nil {
+		return
+	}
+	defer db.lock.unlockShared()
+
+	db.syncExternal()
+}
+
+// syncExternal reloads the file if it changed on disk; callers must hold
+// db.mu for writing and the file lock
+func (db *JSONDatabase) syncExternal() {
+	if db.reload == nil {
+		return
+	}
+
+	changed, sum, err := db.changedOnDisk()
+	if err != nil {
+		log.Printf("ERROR: checking %s for external changes: %v", db.filepath, err)
+		return
+	}
+	if !changed || sum == db.rejected {
+		return
+	}
+
+	data, err := ioutil.ReadFile(db.filepath)
+	if err == nil {
+		// Check it fully before in-memory state is replaced
+		_, _, err = decode(db.cipher, data)
+	}
+	if err == nil {
+		err = db.reload(newTx(db, false, ""))
+	}
+	if err != nil {
+		// Log once per distinct bad content, not on every poll
+		db.rejected = sum
+		log.Printf("ERROR: %s was changed externally but cannot be loaded, keeping current data: %v", db.filepath, err)
+		return
+	}
+
+	// A reload that does not read the file must not leave the old stamp
+	db.remember(data)
+	log.Printf("Reloaded %s after an external change", db.filepath)
+	db.notifyReloaded()
+}
+
+// OnReload registers f to run whenever in-memory state was replaced from
+// the file, after an external edit or a snapshot restore. f runs with the
+// database lock held and must not start a transaction. Register hooks
+// before the database is shared.
+func (db *JSONDatabase) OnReload(f func()) {
+	db.reloaded = append(db.reloaded, f)
+}
+
+func (db *JSONDatabase) notifyReloaded() {
+	for _, f := range db.reloaded {
+		f()
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"gin-framework/database"
+	"gin-framework/repository"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminHandler serves database maintenance endpoints
+type AdminHandler struct {
+	snapshots *repository.Snapshotter // nil when the backend has no file
+}
+
+func NewAdminHandler(snapshots *repository.Snapshotter) *AdminHandler {
+	return &AdminHandler{snapshots: snapshots}
+}
+
+// snapshotsAvailable answers 501 when the backend keeps no database file
+func (h *AdminHandler) snapshotsAvailable(c *gin.Context) bool {
+	if h.snapshots == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Snapshots need a file-backed storage backend"})
+		return false
+	}
+	return true
+}
+
+// ListSnapshots lists the snapshots newest first
+func (h *AdminHandler) ListSnapshots(c *gin.Context) {
+	if !h.snapshotsAvailable(c) {
+		return
+	}
+
+	snapshots, err := h.snapshots.List()
+	if err != nil {
+		storageError(c, err, "Failed to list snapshots")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  snapshots,
+		"count": len(snapshots),
+	})
+}
+
+// CreateSnapshot takes a point-in-time snapshot of the database
+func (h *AdminHandler) CreateSnapshot(c *gin.Context) {
+	if !h.snapshotsAvailable(c) {
+		return
+	}
+
+	info, err := h.snapshots.Create(repository.SnapshotManual)
+	if err != nil {
+		storageError(c, err, "Failed to create snapshot")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Snapshot created successfully",
+		"data":    info,
+	})
+}
+
+// RestoreSnapshot replaces the database with a snapshot
+func (h *AdminHandler) RestoreSnapshot(c *gin.Context) {
+	if !h.snapshotsAvailable(c) {
+		return
+	}
+
+	err := h.snapshots.Restore(c.Param("name"))
+	switch {
+	case errors.Is(err, database.ErrSnapshotNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found"})
+		return
+	case errors.Is(err, database.ErrWrongKey):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Snapshot cannot be decrypted with the configured keys"})
+		return
+	case err != nil:
+		storageError(c, err, "Failed to restore snapshot")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Snapshot restored successfully"})
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"errors"
+	"gin-framework/models"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errPreconditionFailed is returned from inside an update when the task
+// no longer matches the client's If-Match header
+var errPreconditionFailed = errors.New("task version does not match If-Match")
+
+// etag is the entity tag of a task: its version as a strong validator
+func etag(task *models.Task) string {
+	return `"` + strconv.FormatInt(task.Version, 10) + `"`
+}
+
+// setETag adds the ETag header for task to the response
+func setETag(c *gin.Context, task *models.Task) {
+	c.Header("ETag", etag(task))
+}
+
+// matchesETag reports whether the If-Match or If-None-Match header value
+// lists the task's entity tag. "*" matches any task. If-Match uses strong
+// comparison, so weak tags (W/"...") only match when weak is true.
+func matchesETag(header string, task *models.Task, weak bool) bool {
+	tag := etag(task)
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+		if candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch returns a check for the request's If-Match header, or nil when
+// the request is unconditional
+func ifMatch(c *gin.Context) func(task models.Task) error {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return nil
+	}
+	return func(task models.Task) error {
+		if !matchesETag(header, &task, false) {
+			return errPreconditionFailed
+		}
+		return nil
+	}
+}
+
+// notModified answers 304 when the request's If-None-Match header lists
+// the task's current entity tag
+func notModified(c *gin.Context, task *models.Task) bool {
+	header := c.GetHeader("If-None-Match")
+	if header == "" || !matchesETag(header, task, true) {
+		return false
+	}
+	setETag(c, task)
+	c.Status(http.StatusNotModified)
+	return true
+}
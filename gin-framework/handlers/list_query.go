@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"gin-framework/models"
+	"gin-framework/repository"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listQuery is what a task listing asks for besides paging: which tasks
+// and in which order
+type listQuery struct {
+	filter repository.TaskFilter
+	query  *repository.Query // from q=, nil when absent
+	order  repository.SortOrder
+}
+
+// match reports whether task passes both the filter parameters and the
+// query
+func (q listQuery) match(task models.Task) bool {
+	return q.filter.Match(task) && (q.query == nil || q.query.Match(task))
+}
+
+// queryError answers 400 for a bad q= parameter, with the position of
+// the problem so clients can point at it
+func queryError(c *gin.Context, err error) {
+	var qerr *repository.QueryError
+	if errors.As(err, &qerr) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Invalid query at position " + strconv.Itoa(qerr.Position) + ": " + qerr.Message,
+			"position": qerr.Position,
+		})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// parseListQuery reads the filter and sort parameters; the error is meant
+// for the client
+func parseListQuery(c *gin.Context) (listQuery, error) {
+	var q listQuery
+
+	if value, ok := c.GetQuery("completed"); ok {
+		completed, err := strconv.ParseBool(value)
+		if err != nil {
+			return q, errors.New("Invalid completed value, expected true or false")
+		}
+		q.filter.Completed = &completed
+	}
+
+	times := []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"created_after", &q.filter.CreatedAfter},
+		{"created_before", &q.filter.CreatedBefore},
+		{"updated_after", &q.filter.UpdatedAfter},
+		{"updated_before", &q.filter.UpdatedBefore},
+	}
+	for _, t := range times {
+		value := c.Query(t.name)
+		if value == "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return q, errors.New("Invalid " + t.name + " timestamp, expected RFC 3339")
+		}
+		*t.dst = at
+	}
+
+	q.filter.TitleContains = c.Query("title_contains")
+	q.filter.DescriptionContains = c.Query("description_contains")
+
+	if text := c.Query("q"); strings.TrimSpace(text) != "" {
+		query, err := repository.ParseQuery(text)
+		if err != nil {
+			return q, err
+		}
+		q.query = query
+	}
+
+	order, err := repository.ParseSort(c.Query("sort"))
+	if err != nil {
+		return q, errors.New("Invalid sort: " + err.Error())
+	}
+	q.order = order
+	return q, nil
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"gin-framework/models"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func listTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	at, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return at
+}
+
+func listTasks(t *testing.T) []models.Task {
+	return []models.Task{
+		{ID: "1", Title: "Deploy api", Description: "prod rollout", CreatedAt: listTime(t, "2026-10-01T00:00:00Z"), UpdatedAt: listTime(t, "2026-10-03T00:00:00Z")},
+		{ID: "2", Title: "write docs", Completed: true, CreatedAt: listTime(t, "2026-10-02T00:00:00Z"), UpdatedAt: listTime(t, "2026-10-02T00:00:00Z")},
+		{ID: "3", Title: "Deploy web", Description: "Prod", Completed: true, CreatedAt: listTime(t, "2026-10-03T00:00:00Z"), UpdatedAt: listTime(t, "2026-10-03T00:00:00Z")},
+		{ID: "4", Title: "deploy api", CreatedAt: listTime(t, "2026-10-02T00:00:00Z"), UpdatedAt: listTime(t, "2026-10-01T00:00:00Z")},
+	}
+}
+
+func parseTestListQuery(query string) (listQuery, error) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/tasks?"+query, nil)
+	return parseListQuery(c)
+}
+
+// listIDs returns the IDs of the tasks query selects in its order,
+// comma-separated
+func listIDs(t *testing.T, query string) string {
+	t.Helper()
+	q, err := parseTestListQuery(query)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	var tasks []models.Task
+	for _, task := range listTasks(t) {
+		if q.match(task) {
+			tasks = append(tasks, task)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool { return q.order.Less(tasks[i], tasks[j]) })
+
+	ids := make([]string, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.ID.String()
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestListQueryFilters(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "1,2,3,4"},
+		{"completed=true", "2,3"},
+		{"completed=false", "1,4"},
+		{"completed=1", "2,3"},
+		{"title_contains=DEPLOY", "1,3,4"},
+		{"title_contains=api&completed=false", "1,4"},
+		{"description_contains=prod", "1,3"},
+		{"description_contains=", "1,2,3,4"},
+		{"title_contains=nothing", ""},
+	}
+	for _, tt := range tests {
+		if got := listIDs(t, tt.query); got != tt.want {
+			t.Errorf("?%s listed [%s], want [%s]", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestListQueryTimeRanges(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		// Both bounds are exclusive
+		{"created_after=2026-10-02T00:00:00Z", "3"},
+		{"created_before=2026-10-02T00:00:00Z", "1"},
+		{"created_after=2026-10-02T00:00:00Z&created_before=2026-10-03T00:00:00Z", ""},
+		// Inclusive bounds are given just outside the range
+		{"created_after=2026-10-01T23:59:59.999Z&created_before=2026-10-02T00:00:00.001Z", "2,4"},
+		{"created_after=2026-10-01T00:00:00Z&created_before=2026-10-03T00:00:00Z", "2,4"},
+		{"updated_after=2026-10-02T00:00:00Z", "1,3"},
+		{"updated_before=2026-10-03T00:00:00Z", "2,4"},
+		{"updated_after=2026-10-01T00:00:00Z&updated_before=2026-10-03T00:00:00Z", "2"},
+		{"created_after=2026-10-02T02:00:00%2B02:00", "3"}, // the same instant in another zone
+		{"created_before=2026-10-03T00:00:00Z&updated_after=2026-10-02T00:00:00Z", "1"},
+	}
+	for _, tt := range tests {
+		if got := listIDs(t, tt.query); got != tt.want {
+			t.Errorf("?%s listed [%s], want [%s]", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestListQuerySort(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"sort=title", "1,4,3,2"},
+		{"sort=-title", "2,3,4,1"},
+		{"sort=-updated_at,title", "1,3,2,4"},
+		{"sort=-updated_at,-title", "3,1,2,4"},
+		{"sort=completed,-created_at", "4,1,3,2"},
+		// Ties fall back to ascending IDs, whatever the direction
+		{"sort=created_at", "1,2,4,3"},
+		{"sort=-created_at", "3,2,4,1"},
+		{"sort=completed", "1,4,2,3"},
+		{"sort=-completed", "2,3,1,4"},
+		{"sort=-id", "4,3,2,1"},
+		{"sort=%20-updated_at%20,%20title%20", "1,3,2,4"},
+		{"sort=title&completed=true", "3,2"},
+	}
+	for _, tt := range tests {
+		if got := listIDs(t, tt.query); got != tt.want {
+			t.Errorf("?%s listed [%s], want [%s]", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestListQueryErrors(t *testing.T) {
+	tests := []struct {
+		query   string
+		message string
+	}{
+		{"completed=maybe", "Invalid completed value"},
+		{"created_after=yesterday", "Invalid created_after timestamp"},
+		{"updated_before=2026-10-02", "Invalid updated_before timestamp"},
+		{"sort=priority", `unknown sort field "priority", use one of `},
+		{"sort=title,-updated_at,priority", `unknown sort field "priority"`},
+		{"sort=--title", `unknown sort field "-title"`},
+		{"sort=%2Btitle", `unknown sort field "+title"`},
+		{"sort=title:desc", `unknown sort field "title:desc"`},
+		{"sort=title,-title", `sort field "title" is listed twice`},
+		{"sort=-", "empty sort field"},
+		{"sort=title,", "empty sort field"},
+	}
+	for _, tt := range tests {
+		_, err := parseTestListQuery(tt.query)
+		if err == nil {
+			t.Errorf("?%s parsed, want an error with %s", tt.query, tt.message)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("?%s: %v, want ...%s...", tt.query, err, tt.message)
+		}
+	}
+
+	router, _ := newTestRouter(t)
+	for _, target := range []string{"/api/tasks?sort=priority", "/api/tasks/export?sort=priority", "/api/tasks?limit=5&completed=maybe"} {
+		if w := serve(t, router, http.MethodGet, target, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want 400", target, w.Code)
+		}
+	}
+}
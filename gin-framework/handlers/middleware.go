@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"gin-framework/repository"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuthorHeader names the user making a request; it is stored as the
+// author of the revisions the request creates
+const AuthorHeader = "X-User"
+
+// Author copies the X-User header into the request context
+func Author() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		author := c.GetHeader(AuthorHeader)
+		if author == "" {
+			author = "anonymous"
+		}
+		c.Request = c.Request.WithContext(repository.WithAuthor(c.Request.Context(), author))
+		c.Next()
+	}
+}
+
+// AdminToken requires "Authorization: Bearer <token>" when token is set
+func AdminToken(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.Next()
+			return
+		}
+
+		given := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Admin token required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// ReadOnly turns the API into a read-only replica of leader. Writes, and
+// history reads that only the leader can answer, are redirected there
+// with 307 so the method and body are kept.
+func ReadOnly(leader string) gin.HandlerFunc {
+	leader = strings.TrimRight(leader, "/")
+	return func(c *gin.Context) {
+		read := c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead
+		leaderOnly := strings.HasSuffix(c.Request.URL.Path, "/history") || c.Query("as_of") != ""
+		if read && !leaderOnly {
+			c.Next()
+			return
+		}
+
+		location := leader + c.Request.URL.RequestURI()
+		c.Header("Location", location)
+		c.AbortWithStatusJSON(http.StatusTemporaryRedirect, gin.H{"error": "This server is a read-only replica; send the request to the leader", "leader": location})
+	}
+}
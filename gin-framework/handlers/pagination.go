@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"gin-framework/models"
+	"gin-framework/repository"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Page sizes for GET /api/tasks
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 1000
+)
+
+// pageCursor is what an opaque cursor holds: the sort key of the task the
+// page continues from, in which direction, and the order it belongs to
+type pageCursor struct {
+	Sort   string          `json:"sort,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+	Before json.RawMessage `json:"before,omitempty"`
+}
+
+// encodeCursor continues after (or before) task in order. Only the fields
+// the order compares are kept, which keeps the cursor short.
+func encodeCursor(order repository.SortOrder, task models.Task, before bool) string {
+	data, _ := json.Marshal(order.Key(task))
+	var fields map[string]json.RawMessage
+	json.Unmarshal(data, &fields)
+	for name := range fields {
+		if name != "id" && !order.Has(name) {
+			delete(fields, name)
+		}
+	}
+	key, _ := json.Marshal(fields)
+
+	cursor := pageCursor{Sort: order.String()}
+	if before {
+		cursor.Before = key
+	} else {
+		cursor.After = key
+	}
+	data, _ = json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor returns the order a cursor belongs to and the task key it
+// continues after or before
+func decodeCursor(s string) (order string, after, before *models.Task, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return "", nil, nil, err
+	}
+
+	raw := cursor.After
+	if len(cursor.After) == 0 {
+		raw = cursor.Before
+	}
+	if (len(cursor.After) == 0) == (len(cursor.Before) == 0) {
+		return "", nil, nil, errors.New("cursor needs exactly one of after and before")
+	}
+	var key models.Task
+	if err := json.Unmarshal(raw, &key); err != nil {
+		return "", nil, nil, err
+	}
+	if key.ID == "" {
+		return "", nil, nil, errors.New("cursor has no task id")
+	}
+
+	if len(cursor.After) > 0 {
+		return cursor.Sort, &key, nil, nil
+	}
+	return cursor.Sort, nil, &key, nil
+}
+
+// paging is a parsed page request. Offset mode is chosen by passing
+// offset; otherwise the links continue with cursors. Filter and order
+// are set from the listQuery.
+type paging struct {
+	req     repository.PageRequest
+	offsets bool
+}
+
+// wantsPage reports whether the request asks for a page rather than the
+// whole list
+func wantsPage(c *gin.Context) bool {
+	for _, name := range []string{"limit", "offset", "cursor"} {
+		if _, ok := c.GetQuery(name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePaging reads limit, offset and cursor for the tasks q selects; the
+// error is meant for the client
+func parsePaging(c *gin.Context, q listQuery) (paging, error) {
+	p := paging{req: repository.PageRequest{
+		Limit: defaultPageLimit,
+		Match: q.match,
+		Order: q.order,
+	}}
+
+	if value, ok := c.GetQuery("limit"); ok {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return p, errors.New("Limit must be a number from 1 to " + strconv.Itoa(maxPageLimit))
+		}
+		p.req.Limit = limit
+	}
+
+	offset, hasOffset := c.GetQuery("offset")
+	cursor, hasCursor := c.GetQuery("cursor")
+	switch {
+	case hasOffset && hasCursor:
+		return p, errors.New("Use either offset or cursor, not both")
+	case hasOffset:
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			return p, errors.New("Offset must be a number of at least 0")
+		}
+		p.req.Offset = n
+		p.offsets = true
+	case hasCursor && cursor != "":
+		order, after, before, err := decodeCursor(cursor)
+		if err != nil {
+			return p, errors.New("Invalid cursor")
+		}
+		if order != q.order.String() {
+			return p, errors.New("Cursor belongs to another sort order; start again without it")
+		}
+		p.req.After, p.req.Before = after, before
+	}
+	return p, nil
+}
+
+// pageLinks returns the URLs of the first, next and previous pages, which
+// keep every other query parameter of the request. An empty URL means
+// there is no such page.
+func pageLinks(c *gin.Context, p paging, page *repository.Page) (first, next, prev string) {
+	link := func(set func(query url.Values)) string {
+		query := c.Request.URL.Query()
+		query.Del("offset")
+		query.Del("cursor")
+		query.Set("limit", strconv.Itoa(p.req.Limit))
+		set(query)
+		return c.Request.URL.Path + "?" + query.Encode()
+	}
+
+	if p.offsets {
+		first = link(func(query url.Values) { query.Set("offset", "0") })
+		if page.HasNext {
+			next = link(func(query url.Values) {
+				query.Set("offset", strconv.Itoa(p.req.Offset+p.req.Limit))
+			})
+		}
+		if page.HasPrev {
+			offset := p.req.Offset - p.req.Limit
+			if offset < 0 {
+				offset = 0
+			}
+			if offset > page.Total {
+				// Past the end: go back to the last full page
+				offset = page.Total - p.req.Limit
+				if offset < 0 {
+					offset = 0
+				}
+			}
+			prev = link(func(query url.Values) { query.Set("offset", strconv.Itoa(offset)) })
+		}
+		return first, next, prev
+	}
+
+	first = link(func(url.Values) {})
+	if len(page.Tasks) == 0 {
+		// Nothing to continue from; first still leads back
+		return first, "", ""
+	}
+	if page.HasNext {
+		cursor := encodeCursor(p.req.Order, page.Tasks[len(page.Tasks)-1], false)
+		next = link(func(query url.Values) { query.Set("cursor", cursor) })
+	}
+	if page.HasPrev {
+		cursor := encodeCursor(p.req.Order, page.Tasks[0], true)
+		prev = link(func(query url.Values) { query.Set("cursor", cursor) })
+	}
+	return first, next, prev
+}
+
+// linkHeader formats the RFC 8288 Link header for the page links
+func linkHeader(first, next, prev string) string {
+	var links []string
+	for _, l := range []struct{ url, rel string }{{first, "first"}, {prev, "prev"}, {next, "next"}} {
+		if l.url != "" {
+			links = append(links, "<"+l.url+`>; rel="`+l.rel+`"`)
+		}
+	}
+	return strings.Join(links, ", ")
+}
+
+// optionalLink is a link for the response body, null when there is none
+func optionalLink(u string) interface{} {
+	if u == "" {
+		return nil
+	}
+	return u
+}
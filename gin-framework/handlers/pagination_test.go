@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"gin-framework/models"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pageResponse is the body of GET /api/tasks with paging
+type pageResponse struct {
+	Data  []models.Task `json:"data"`
+	Count int           `json:"count"`
+	Total int           `json:"total"`
+	Limit int           `json:"limit"`
+	Next  *string       `json:"next"`
+	Prev  *string       `json:"prev"`
+}
+
+var linkPart = regexp.MustCompile(`^<([^>]*)>; rel="(first|next|prev)"$`)
+
+// getPage fetches target and checks that the Link header and the body
+// agree on the next and previous pages
+func getPage(t *testing.T, router *gin.Engine, target string) pageResponse {
+	t.Helper()
+	w := serve(t, router, http.MethodGet, target, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d %s", target, w.Code, w.Body)
+	}
+	var page pageResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	if page.Count != len(page.Data) {
+		t.Errorf("GET %s: count %d for %d tasks", target, page.Count, len(page.Data))
+	}
+
+	links := map[string]string{}
+	for _, part := range strings.Split(w.Header().Get("Link"), ", ") {
+		m := linkPart.FindStringSubmatch(part)
+		if m == nil {
+			t.Fatalf("GET %s: malformed Link header %q", target, w.Header().Get("Link"))
+		}
+		links[m[2]] = m[1]
+	}
+	if links["first"] == "" {
+		t.Errorf("GET %s: Link header has no first page", target)
+	}
+	for rel, body := range map[string]*string{"next": page.Next, "prev": page.Prev} {
+		if (body == nil) != (links[rel] == "") || body != nil && *body != links[rel] {
+			t.Errorf("GET %s: %s is %v in the body but %q in the Link header", target, rel, body, links[rel])
+		}
+	}
+	return page
+}
+
+func pageTitles(tasks []models.Task) []string {
+	titles := make([]string, len(tasks))
+	for i, task := range tasks {
+		titles[i] = task.Title
+	}
+	return titles
+}
+
+func TestCursorPagingUnderInserts(t *testing.T) {
+	router, _ := newTestRouter(t)
+	for _, title := range strings.Fields("t1 t2 t3 t4 t5 t6 t7") {
+		createTestTask(t, router, title)
+	}
+
+	var seen []string
+	page := getPage(t, router, "/api/tasks?limit=3")
+	if page.Prev != nil {
+		t.Errorf("first page has a prev link %s", *page.Prev)
+	}
+	for i := 0; ; i++ {
+		if page.Limit != 3 {
+			t.Fatalf("limit %d, want 3", page.Limit)
+		}
+		seen = append(seen, pageTitles(page.Data)...)
+		if page.Next == nil {
+			break
+		}
+		if !strings.Contains(*page.Next, "limit=3") {
+			t.Errorf("next link %s drops the limit", *page.Next)
+		}
+		// Tasks created meanwhile show up on the pages still to come,
+		// and removing one already read shifts nothing
+		switch i {
+		case 0:
+			createTestTask(t, router, "n1")
+			createTestTask(t, router, "n2")
+			serve(t, router, http.MethodDelete, "/api/tasks/1", "")
+		case 1:
+			createTestTask(t, router, "n3")
+		}
+		page = getPage(t, router, *page.Next)
+	}
+
+	want := "t1 t2 t3 t4 t5 t6 t7 n1 n2 n3"
+	if got := strings.Join(seen, " "); got != want {
+		t.Fatalf("pages held %s, want %s", got, want)
+	}
+	if page.Total != 9 {
+		t.Errorf("last page total %d, want 9", page.Total)
+	}
+
+	// Going back from the last page reaches every task before it once
+	var back []string
+	for page.Prev != nil {
+		page = getPage(t, router, *page.Prev)
+		back = append(pageTitles(page.Data), back...)
+	}
+	want = "t2 t3 t4 t5 t6 t7 n1 n2"
+	if got := strings.Join(back, " "); got != want {
+		t.Fatalf("prev pages held %s, want %s", got, want)
+	}
+	if page.Next == nil {
+		t.Error("first page reached backwards has no next link")
+	}
+}
+
+func TestSortedCursorPagingUnderInserts(t *testing.T) {
+	router, _ := newTestRouter(t)
+	for _, title := range strings.Fields("b01 b02 b03 b04 b05 b06 b07 b08 b09 b10") {
+		createTestTask(t, router, title)
+	}
+	// Created between the page fetches: those sorting before the page
+	// already read stay out, the others show up in order
+	inserts := [][]string{{"a1", "c1"}, {"b035", "b065"}, {"b075"}}
+
+	var seen []string
+	page := getPage(t, router, "/api/tasks?sort=title&limit=3")
+	if page.Prev != nil {
+		t.Errorf("first page has a prev link %s", *page.Prev)
+	}
+	for i := 0; ; i++ {
+		if page.Limit != 3 {
+			t.Fatalf("limit %d, want 3", page.Limit)
+		}
+		seen = append(seen, pageTitles(page.Data)...)
+		if page.Next == nil {
+			break
+		}
+		if !strings.Contains(*page.Next, "sort=title") || !strings.Contains(*page.Next, "limit=3") {
+			t.Errorf("next link %s drops the request parameters", *page.Next)
+		}
+		if i < len(inserts) {
+			for _, title := range inserts[i] {
+				createTestTask(t, router, title)
+			}
+		}
+		page = getPage(t, router, *page.Next)
+	}
+
+	want := "b01 b02 b03 b04 b05 b06 b065 b07 b08 b09 b10 c1"
+	if got := strings.Join(seen, " "); got != want {
+		t.Fatalf("pages held %s, want %s", got, want)
+	}
+	if page.Total != 15 {
+		t.Errorf("last page total %d, want 15", page.Total)
+	}
+
+	// Going back from the last page reaches every task once, including
+	// the ones inserted before pages already read
+	var back []string
+	for page.Prev != nil {
+		page = getPage(t, router, *page.Prev)
+		back = append(pageTitles(page.Data), back...)
+	}
+	want = "a1 b01 b02 b03 b035 b04 b05 b06 b065 b07 b075 b08"
+	if got := strings.Join(back, " "); got != want {
+		t.Fatalf("prev pages held %s, want %s", got, want)
+	}
+	if len(page.Data) != 3 || page.Next == nil {
+		t.Errorf("first page reached backwards has %d tasks and next %v", len(page.Data), page.Next)
+	}
+}
+
+func TestPagingBadRequests(t *testing.T) {
+	router, _ := newTestRouter(t)
+	for _, title := range strings.Fields("a b c d") {
+		createTestTask(t, router, title)
+	}
+	page := getPage(t, router, "/api/tasks?limit=2")
+	cursor := strings.SplitN(strings.SplitN(*page.Next, "cursor=", 2)[1], "&", 2)[0]
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		t.Fatalf("cursor %s is not base64url: %v", cursor, err)
+	}
+	tampered := base64.RawURLEncoding.EncodeToString([]byte(strings.Replace(string(raw), `"after"`, `"later"`, 1)))
+	neither := base64.RawURLEncoding.EncodeToString([]byte(`{"sort":""}`))
+	noID := base64.RawURLEncoding.EncodeToString([]byte(`{"after":{"title":"b"}}`))
+	both := base64.RawURLEncoding.EncodeToString([]byte(`{"after":{"id":"1"},"before":{"id":"3"}}`))
+
+	for _, target := range []string{
+		"/api/tasks?limit=0",
+		"/api/tasks?limit=1001",
+		"/api/tasks?limit=-1",
+		"/api/tasks?limit=two",
+		"/api/tasks?offset=-1",
+		"/api/tasks?offset=1&cursor=" + cursor,
+		"/api/tasks?cursor=" + cursor[:len(cursor)-3] + "!!!",
+		"/api/tasks?cursor=" + tampered,
+		"/api/tasks?cursor=" + neither,
+		"/api/tasks?cursor=" + noID,
+		"/api/tasks?cursor=" + both,
+		"/api/tasks?sort=title&cursor=" + cursor, // from the ID order
+	} {
+		if w := serve(t, router, http.MethodGet, target, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want 400", target, w.Code)
+		}
+	}
+
+	if page := getPage(t, router, "/api/tasks?limit=1000"); page.Total != 4 || page.Next != nil {
+		t.Errorf("limit=1000 gave total %d and next %v, want all 4 tasks on one page", page.Total, page.Next)
+	}
+}
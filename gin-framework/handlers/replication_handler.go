@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"gin-framework/repository"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReplicationHandler serves the change log to followers on the leader and
+// reports the replication state on either side
+type ReplicationHandler struct {
+	changes  *repository.ChangeLog // nil on a follower
+	follower *repository.Follower  // nil on the leader
+}
+
+func NewReplicationHandler(changes *repository.ChangeLog, follower *repository.Follower) *ReplicationHandler {
+	return &ReplicationHandler{changes: changes, follower: follower}
+}
+
+// isLeader answers 501 on a follower, which has no change log to serve
+func (h *ReplicationHandler) isLeader(c *gin.Context) bool {
+	if h.changes == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "This server is a follower; replicate from the leader"})
+		return false
+	}
+	return true
+}
+
+// Status reports the role of this server and its position
+func (h *ReplicationHandler) Status(c *gin.Context) {
+	if h.follower != nil {
+		c.JSON(http.StatusOK, gin.H{"role": "follower", "data": h.follower.Status()})
+		return
+	}
+	epoch, seq := h.changes.Position()
+	c.JSON(http.StatusOK, gin.H{"role": "leader", "data": gin.H{"epoch": epoch, "seq": seq}})
+}
+
+// Snapshot returns every task, trashed ones included, with the change log
+// position to continue from
+func (h *ReplicationHandler) Snapshot(c *gin.Context) {
+	if !h.isLeader(c) {
+		return
+	}
+
+	snapshot, err := h.changes.Snapshot(c.Request.Context())
+	if err != nil {
+		storageError(c, err, "Failed to read tasks")
+		return
+	}
+	c.JSON(http.StatusOK, snapshot)
+}
+
+// Changes streams the changes after ?since=<seq> in ?epoch=<epoch> as
+// newline-delimited JSON. With ?follow=true the stream stays open and new
+// changes are sent as they are committed, with an empty line as heartbeat.
+// 410 Gone means the changes are no longer kept and the follower has to
+// take a new snapshot.
+func (h *ReplicationHandler) Changes(c *gin.Context) {
+	if !h.isLeader(c) {
+		return
+	}
+
+	since, err := strconv.ParseUint(c.DefaultQuery("since", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since, expected a change sequence number"})
+		return
+	}
+	epoch := c.Query("epoch")
+	follow := c.Query("follow") == "true"
+
+	changes, wait, err := h.changes.Since(epoch, since)
+	if errors.Is(err, repository.ErrChangesGone) {
+		current, seq := h.changes.Position()
+		c.JSON(http.StatusGone, gin.H{"error": "Changes are no longer available; take a new snapshot", "epoch": current, "seq": seq})
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+	heartbeat := time.NewTicker(repository.ReplicationHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		for _, change := range changes {
+			if enc.Encode(change) != nil {
+				return
+			}
+			since = change.Seq
+		}
+		c.Writer.Flush()
+		if !follow {
+			return
+		}
+
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := c.Writer.Write([]byte("\n")); err != nil {
+				return
+			}
+			changes = nil
+			continue
+		case <-wait:
+		}
+
+		// A new epoch ends the stream; the follower reconnects and gets 410
+		if changes, wait, err = h.changes.Since(epoch, since); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"gin-framework/repository"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Result sizes for GET /api/search
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchHandler serves full-text search over task titles and
+// descriptions
+type SearchHandler struct {
+	index *repository.SearchIndex
+}
+
+func NewSearchHandler(index *repository.SearchIndex) *SearchHandler {
+	return &SearchHandler{index: index}
+}
+
+// Search ranks the tasks matching ?q= and returns them with highlighted
+// snippets, best first
+func (h *SearchHandler) Search(c *gin.Context) {
+	query := c.Query("q")
+	if strings.TrimSpace(query) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing search query, pass it in q"})
+		return
+	}
+
+	limit := defaultSearchLimit
+	if value, ok := c.GetQuery("limit"); ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Limit must be a number from 1 to " + strconv.Itoa(maxSearchLimit)})
+			return
+		}
+		limit = n
+	}
+	offset := 0
+	if value, ok := c.GetQuery("offset"); ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Offset must be a number of at least 0"})
+			return
+		}
+		offset = n
+	}
+
+	result, err := h.index.Search(c.Request.Context(), query, limit, offset)
+	if err == repository.ErrEmptySearch {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query has no words to look for"})
+		return
+	}
+	if err != nil {
+		storageError(c, err, "Failed to search tasks")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":   result.Hits,
+		"count":  len(result.Hits),
+		"total":  result.Total,
+		"query":  query,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
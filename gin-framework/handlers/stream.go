@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"gin-framework/models"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// taskStream writes tasks to the response while they are scanned, so a
+// large store is never held in memory as a whole. Nothing is sent before
+// the first task, so an error up to that point still gets a proper status.
+type taskStream struct {
+	c           *gin.Context
+	contentType string
+	open, sep   string
+	close       func(count int) string
+	count       int
+	started     bool
+	err         error // a task that could not be encoded
+}
+
+// newListStream writes {"data": [...], "count": N}, the shape of the
+// list endpoints
+func newListStream(c *gin.Context) *taskStream {
+	return &taskStream{
+		c:           c,
+		contentType: "application/json; charset=utf-8",
+		open:        `{"data":[`,
+		sep:         ",",
+		close: func(count int) string {
+			return `],"count":` + strconv.Itoa(count) + "}"
+		},
+	}
+}
+
+// newNDJSONStream writes one task per line
+func newNDJSONStream(c *gin.Context) *taskStream {
+	return &taskStream{
+		c:           c,
+		contentType: "application/x-ndjson",
+		sep:         "\n",
+		close: func(count int) string {
+			if count == 0 {
+				return ""
+			}
+			return "\n"
+		},
+	}
+}
+
+func (s *taskStream) start() error {
+	s.started = true
+	s.c.Header("Content-Type", s.contentType)
+	s.c.Status(http.StatusOK)
+	_, err := io.WriteString(s.c.Writer, s.open)
+	return err
+}
+
+// write sends one task and reports whether to go on: false when the
+// client is gone or the task could not be encoded
+func (s *taskStream) write(task models.Task) bool {
+	data, err := json.Marshal(task)
+	if err != nil {
+		s.err = err
+		return false
+	}
+
+	sep := s.sep
+	if !s.started {
+		if s.start() != nil {
+			return false
+		}
+		sep = ""
+	}
+	if _, err := io.WriteString(s.c.Writer, sep); err != nil {
+		return false
+	}
+	if _, err := s.c.Writer.Write(data); err != nil {
+		return false
+	}
+	s.count++
+	return true
+}
+
+// finish completes the response once scanning returned err. An error,
+// from scanning or from encoding a task, after the first task leaves the
+// body unterminated, so clients see it is incomplete.
+func (s *taskStream) finish(err error, message string) {
+	if err == nil {
+		err = s.err
+	}
+	if err != nil {
+		if !s.started {
+			storageError(s.c, err, message)
+			return
+		}
+		log.Printf("ERROR: %s after %d task(s): %v", message, s.count, err)
+		return
+	}
+
+	if !s.started && s.start() != nil {
+		return
+	}
+	io.WriteString(s.c.Writer, s.close(s.count))
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"gin-framework/repository"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Suggestion counts for GET /api/tasks/suggest
+const (
+	defaultSuggestLimit = 10
+	maxSuggestLimit     = repository.MaxSuggestions
+)
+
+// SuggestHandler serves type-ahead suggestions from existing task titles
+type SuggestHandler struct {
+	titles *repository.TitleSuggester
+}
+
+func NewSuggestHandler(titles *repository.TitleSuggester) *SuggestHandler {
+	return &SuggestHandler{titles: titles}
+}
+
+// Suggest lists existing titles with a word starting with ?prefix=,
+// ignoring case and diacritics
+func (h *SuggestHandler) Suggest(c *gin.Context) {
+	prefix := c.Query("prefix")
+
+	limit := defaultSuggestLimit
+	if value, ok := c.GetQuery("limit"); ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxSuggestLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Limit must be a number from 1 to " + strconv.Itoa(maxSuggestLimit)})
+			return
+		}
+		limit = n
+	}
+
+	suggestions, err := h.titles.Suggest(prefix, limit)
+	if err == repository.ErrEmptyPrefix {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing prefix, pass the start of a title in prefix"})
+		return
+	}
+	if err != nil {
+		storageError(c, err, "Failed to suggest titles")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":   suggestions,
+		"count":  len(suggestions),
+		"prefix": prefix,
+	})
+}
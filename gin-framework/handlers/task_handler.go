@@ -1,60 +1,176 @@
 package handlers
 
 import (
+	"errors"
 	"gin-framework/database"
 	"gin-framework/models"
+	"gin-framework/repository"
 	"net/http"
-	"strconv"
+	"sort"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type TaskHandler struct {
-	db *database.JSONDatabase
+	repo    *repository.SoftDeleteRepository
+	history *repository.HistoryRepository
+	ids     repository.IDGenerator
 }
 
-func NewTaskHandler(db *database.JSONDatabase) *TaskHandler {
-	return &TaskHandler{db: db}
+func NewTaskHandler(repo *repository.SoftDeleteRepository, history *repository.HistoryRepository, ids repository.IDGenerator) *TaskHandler {
+	return &TaskHandler{repo: repo, history: history, ids: ids}
 }
 
-// GetAllTasks retrieves all tasks
+// taskID reads the :id parameter; on failure it has already answered 400
+func taskID(c *gin.Context, ids repository.IDGenerator) (models.TaskID, bool) {
+	id, err := ids.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+		return "", false
+	}
+	return id, true
+}
+
+// storageError answers a failed repository call. message is used for
+// errors the client cannot do anything about.
+func storageError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, repository.ErrTaskNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+	case errors.Is(err, errPreconditionFailed):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Task was modified since you read it; fetch it again and retry"})
+	case errors.Is(err, database.ErrLockTimeout):
+		// Another process holds db.json; the request can simply be retried
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database is busy, please retry"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// GetAllTasks retrieves the tasks matching the filter parameters in the
+// requested order, all of them or one page when limit, offset or cursor is
+// given
 func (h *TaskHandler) GetAllTasks(c *gin.Context) {
-	var tasks []models.Task
+	q, err := parseListQuery(c)
+	if err != nil {
+		queryError(c, err)
+		return
+	}
+	if !wantsPage(c) {
+		h.streamTasks(c, newListStream(c), q, "Failed to read tasks")
+		return
+	}
 
-	if err := h.db.ReadData(&tasks); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read tasks"})
+	p, err := parsePaging(c, q)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page, err := repository.Paginate(c.Request.Context(), h.repo.Scan, p.req)
+	if err != nil {
+		storageError(c, err, "Failed to read tasks")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"data":  tasks,
-		"count": len(tasks),
-	})
+	first, next, prev := pageLinks(c, p, page)
+	c.Header("Link", linkHeader(first, next, prev))
+	response := gin.H{
+		"data":  page.Tasks,
+		"count": len(page.Tasks),
+		"total": page.Total,
+		"limit": p.req.Limit,
+		"next":  optionalLink(next),
+		"prev":  optionalLink(prev),
+	}
+	if p.offsets {
+		response["offset"] = p.req.Offset
+	}
+	c.JSON(http.StatusOK, response)
 }
 
-// GetTaskByID retrieves a single task by ID
-func (h *TaskHandler) GetTaskByID(c *gin.Context) {
-	id, err := strconv.Atoi(c.Param("id"))
+// ExportTasks streams the tasks as newline-delimited JSON, taking the
+// same filter and sort parameters as GetAllTasks
+func (h *TaskHandler) ExportTasks(c *gin.Context) {
+	q, err := parseListQuery(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+		queryError(c, err)
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="tasks.ndjson"`)
+	h.streamTasks(c, newNDJSONStream(c), q, "Failed to export tasks")
+}
+
+// streamTasks writes the tasks q selects to stream. Tasks in ID order go
+// out while they are scanned; any other order needs the matching tasks
+// in memory to sort them first.
+func (h *TaskHandler) streamTasks(c *gin.Context, stream *taskStream, q listQuery, message string) {
+	ctx := c.Request.Context()
+	if len(q.order) == 0 {
+		err := h.repo.Scan(ctx, func(task models.Task) bool {
+			return !q.match(task) || stream.write(task)
+		})
+		stream.finish(err, message)
 		return
 	}
 
 	var tasks []models.Task
-	if err := h.db.ReadData(&tasks); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read tasks"})
+	err := h.repo.Scan(ctx, func(task models.Task) bool {
+		if q.match(task) {
+			tasks = append(tasks, task)
+		}
+		return true
+	})
+	if err == nil {
+		sort.Slice(tasks, func(i, j int) bool {
+			return q.order.Less(tasks[i], tasks[j])
+		})
+		for _, task := range tasks {
+			if !stream.write(task) {
+				break
+			}
+		}
+	}
+	stream.finish(err, message)
+}
+
+// GetTaskByID retrieves a single task by ID, or with ?as_of=<RFC 3339
+// timestamp> the task as it was at that time
+func (h *TaskHandler) GetTaskByID(c *gin.Context) {
+	id, ok := taskID(c, h.ids)
+	if !ok {
 		return
 	}
 
-	for _, task := range tasks {
-		if task.ID == id {
-			c.JSON(http.StatusOK, gin.H{"data": task})
+	if value := c.Query("as_of"); value != "" {
+		at, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid as_of timestamp, expected RFC 3339"})
 			return
 		}
+
+		task, err := h.history.GetAsOf(c.Request.Context(), id, at)
+		if err != nil {
+			storageError(c, err, "Failed to read task history")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": task, "as_of": at})
+		return
 	}
 
-	c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+	task, err := h.repo.Get(c.Request.Context(), id)
+	if err != nil {
+		storageError(c, err, "Failed to read tasks")
+		return
+	}
+	if notModified(c, task) {
+		return
+	}
+
+	setETag(c, task)
+	c.JSON(http.StatusOK, gin.H{"data": task})
 }
 
 // CreateTask creates a new task
@@ -66,39 +182,28 @@ func (h *TaskHandler) CreateTask(c *gin.Context) {
 		return
 	}
 
-	var tasks []models.Task
-	if err := h.db.ReadData(&tasks); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read tasks"})
+	tags, err := models.NormalizeTags(input.Tags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tags: " + err.Error()})
 		return
 	}
 
-	// Generate new ID
-	newID := 1
-	if len(tasks) > 0 {
-		// Find max ID
-		for _, task := range tasks {
-			if task.ID >= newID {
-				newID = task.ID + 1
-			}
-		}
-	}
-
 	newTask := models.Task{
-		ID:          newID,
 		Title:       input.Title,
 		Description: input.Description,
 		Completed:   false,
+		Tags:        tags,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
 
-	tasks = append(tasks, newTask)
-
-	if err := h.db.WriteData(tasks); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save task"})
+	// The repository assigns the ID
+	if err := h.repo.Create(c.Request.Context(), &newTask); err != nil {
+		storageError(c, err, "Failed to save task")
 		return
 	}
 
+	setETag(c, &newTask)
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Task created successfully",
 		"data":    newTask,
@@ -107,9 +212,8 @@ func (h *TaskHandler) CreateTask(c *gin.Context) {
 
 // UpdateTask updates an existing task
 func (h *TaskHandler) UpdateTask(c *gin.Context) {
-	id, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+	id, ok := taskID(c, h.ids)
+	if !ok {
 		return
 	}
 
@@ -119,80 +223,111 @@ func (h *TaskHandler) UpdateTask(c *gin.Context) {
 		return
 	}
 
-	var tasks []models.Task
-	if err := h.db.ReadData(&tasks); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read tasks"})
-		return
+	var tags []string
+	if input.Tags != nil {
+		var err error
+		if tags, err = models.NormalizeTags(*input.Tags); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tags: " + err.Error()})
+			return
+		}
 	}
 
-	found := false
-	for i, task := range tasks {
-		if task.ID == id {
-			found = true
-
-			// Update only provided fields
-			if input.Title != nil {
-				tasks[i].Title = *input.Title
-			}
-			if input.Description != nil {
-				tasks[i].Description = *input.Description
-			}
-			if input.Completed != nil {
-				tasks[i].Completed = *input.Completed
-			}
-			tasks[i].UpdatedAt = time.Now()
-
-			if err := h.db.WriteData(tasks); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update task"})
-				return
+	check := ifMatch(c)
+	updated, err := h.repo.Update(c.Request.Context(), id, func(task *models.Task) error {
+		if check != nil {
+			if err := check(*task); err != nil {
+				return err
 			}
+		}
 
-			c.JSON(http.StatusOK, gin.H{
-				"message": "Task updated successfully",
-				"data":    tasks[i],
-			})
-			return
+		// Update only provided fields
+		if input.Title != nil {
+			task.Title = *input.Title
+		}
+		if input.Description != nil {
+			task.Description = *input.Description
 		}
+		if input.Completed != nil {
+			task.Completed = *input.Completed
+		}
+		if input.Tags != nil {
+			task.Tags = tags
+		}
+		task.UpdatedAt = time.Now()
+		return nil
+	})
+	if err != nil {
+		storageError(c, err, "Failed to update task")
+		return
 	}
 
-	if !found {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
-	}
+	setETag(c, updated)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Task updated successfully",
+		"data":    updated,
+	})
 }
 
-// DeleteTask deletes a task by ID
+// DeleteTask moves a task to the trash
 func (h *TaskHandler) DeleteTask(c *gin.Context) {
-	id, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+	id, ok := taskID(c, h.ids)
+	if !ok {
 		return
 	}
 
-	var tasks []models.Task
-	if err := h.db.ReadData(&tasks); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read tasks"})
+	if err := h.repo.DeleteIf(c.Request.Context(), id, ifMatch(c)); err != nil {
+		storageError(c, err, "Failed to delete task")
 		return
 	}
 
-	found := false
-	for i, task := range tasks {
-		if task.ID == id {
-			found = true
-			// Remove task from slice
-			tasks = append(tasks[:i], tasks[i+1:]...)
-			break
-		}
+	c.JSON(http.StatusOK, gin.H{"message": "Task moved to trash"})
+}
+
+// GetTaskHistory lists the revisions of a task oldest first
+func (h *TaskHandler) GetTaskHistory(c *gin.Context) {
+	id, ok := taskID(c, h.ids)
+	if !ok {
+		return
 	}
 
-	if !found {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+	revisions, err := h.history.History(c.Request.Context(), id)
+	if err != nil {
+		storageError(c, err, "Failed to read task history")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  revisions,
+		"count": len(revisions),
+	})
+}
+
+// RevertTask brings a task back to the content of an earlier revision
+func (h *TaskHandler) RevertTask(c *gin.Context) {
+	id, ok := taskID(c, h.ids)
+	if !ok {
 		return
 	}
 
-	if err := h.db.WriteData(tasks); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete task"})
+	var input models.RevertTaskInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Task deleted successfully"})
+	task, err := h.history.Revert(c.Request.Context(), id, input.Revision)
+	if errors.Is(err, repository.ErrRevisionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+	if err != nil {
+		storageError(c, err, "Failed to revert task")
+		return
+	}
+
+	setETag(c, task)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Task reverted successfully",
+		"data":    task,
+	})
 }
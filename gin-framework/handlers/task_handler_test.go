@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"gin-framework/models"
+	"gin-framework/repository"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestRouter serves the task routes from a memory backend, wrapped in
+// history and the trash like in main
+func newTestRouter(t *testing.T) (*gin.Engine, *repository.SoftDeleteRepository) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	ids := repository.SequenceGenerator{}
+	history := repository.NewHistoryRepository(repository.NewMemoryTaskRepository(ids), repository.NewMemoryRevisionStore())
+	trash := repository.NewSoftDeleteRepository(history)
+	h := NewTaskHandler(trash, history, ids)
+
+	router := gin.New()
+	router.GET("/api/tasks", h.GetAllTasks)
+	router.GET("/api/tasks/export", h.ExportTasks)
+	router.GET("/api/tasks/:id", h.GetTaskByID)
+	router.POST("/api/tasks", h.CreateTask)
+	router.PUT("/api/tasks/:id", h.UpdateTask)
+	router.DELETE("/api/tasks/:id", h.DeleteTask)
+	return router, trash
+}
+
+// serve sends one request with the given header name/value pairs
+func serve(t *testing.T, router *gin.Engine, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// taskResponse decodes {"data": task}
+func taskResponse(t *testing.T, w *httptest.ResponseRecorder) models.Task {
+	t.Helper()
+	var body struct {
+		Data models.Task `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	return body.Data
+}
+
+func createTestTask(t *testing.T, router *gin.Engine, title string) models.Task {
+	t.Helper()
+	w := serve(t, router, http.MethodPost, "/api/tasks", `{"title":"`+title+`"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /api/tasks = %d %s", w.Code, w.Body)
+	}
+	return taskResponse(t, w)
+}
+
+func TestGetIfNoneMatch(t *testing.T) {
+	router, _ := newTestRouter(t)
+	createTestTask(t, router, "one")
+
+	tests := []struct {
+		ifNoneMatch string
+		want        int
+	}{
+		{"", http.StatusOK},
+		{`"1"`, http.StatusNotModified},
+		{`W/"1"`, http.StatusNotModified}, // weak comparison
+		{`"3", "1"`, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{`"2"`, http.StatusOK},
+		{`1`, http.StatusOK}, // not a quoted tag
+	}
+	for _, tt := range tests {
+		w := serve(t, router, http.MethodGet, "/api/tasks/1", "", "If-None-Match", tt.ifNoneMatch)
+		if w.Code != tt.want {
+			t.Errorf("If-None-Match: %s got %d, want %d", tt.ifNoneMatch, w.Code, tt.want)
+			continue
+		}
+		if got := w.Header().Get("ETag"); got != `"1"` {
+			t.Errorf("If-None-Match: %s got ETag %s, want \"1\"", tt.ifNoneMatch, got)
+		}
+		if tt.want == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Errorf("If-None-Match: %s got a body with 304: %s", tt.ifNoneMatch, w.Body)
+		}
+	}
+}
+
+func TestUpdateIfMatch(t *testing.T) {
+	router, _ := newTestRouter(t)
+	createTestTask(t, router, "one")
+
+	tests := []struct {
+		ifMatch string
+		want    int
+		etag    string // after the request
+	}{
+		{`"1"`, http.StatusOK, `"2"`},
+		{`"1"`, http.StatusPreconditionFailed, `"2"`},   // stale
+		{`W/"2"`, http.StatusPreconditionFailed, `"2"`}, // strong comparison
+		{`2`, http.StatusPreconditionFailed, `"2"`},     // not a quoted tag
+		{`"7", "2"`, http.StatusOK, `"3"`},
+		{"*", http.StatusOK, `"4"`},
+		{"", http.StatusOK, `"5"`},
+	}
+	for i, tt := range tests {
+		title := "update " + string(rune('a'+i))
+		w := serve(t, router, http.MethodPut, "/api/tasks/1", `{"title":"`+title+`"}`, "If-Match", tt.ifMatch)
+		if w.Code != tt.want {
+			t.Errorf("If-Match: %s got %d, want %d", tt.ifMatch, w.Code, tt.want)
+		}
+		if w.Code == http.StatusOK && w.Header().Get("ETag") != tt.etag {
+			t.Errorf("If-Match: %s got ETag %s, want %s", tt.ifMatch, w.Header().Get("ETag"), tt.etag)
+		}
+
+		task := taskResponse(t, serve(t, router, http.MethodGet, "/api/tasks/1", ""))
+		if etag(&task) != tt.etag {
+			t.Errorf("If-Match: %s left version %d, want ETag %s", tt.ifMatch, task.Version, tt.etag)
+		}
+		if tt.want == http.StatusPreconditionFailed && task.Title == title {
+			t.Errorf("If-Match: %s failed but the title was still changed", tt.ifMatch)
+		}
+	}
+
+	if w := serve(t, router, http.MethodPut, "/api/tasks/9", `{"title":"x"}`, "If-Match", "*"); w.Code != http.StatusNotFound {
+		t.Errorf("If-Match: * on an unknown task got %d, want 404", w.Code)
+	}
+}
+
+func TestDeleteIfMatch(t *testing.T) {
+	router, trash := newTestRouter(t)
+	createTestTask(t, router, "one")
+	serve(t, router, http.MethodPut, "/api/tasks/1", `{"completed":true}`)
+
+	if w := serve(t, router, http.MethodDelete, "/api/tasks/1", "", "If-Match", `"1"`); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("DELETE with a stale If-Match got %d, want 412", w.Code)
+	}
+	if w := serve(t, router, http.MethodGet, "/api/tasks/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("task is gone after a failed conditional delete: %d", w.Code)
+	}
+
+	if w := serve(t, router, http.MethodDelete, "/api/tasks/1", "", "If-Match", `"2"`); w.Code != http.StatusOK {
+		t.Fatalf("DELETE with the current If-Match got %d %s, want 200", w.Code, w.Body)
+	}
+	trashed, err := trash.Trash(context.Background())
+	if err != nil || len(trashed) != 1 {
+		t.Fatalf("Trash = %v, %v, want the deleted task", trashed, err)
+	}
+	if w := serve(t, router, http.MethodDelete, "/api/tasks/1", "", "If-Match", "*"); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE of a trashed task with If-Match: * got %d, want 404", w.Code)
+	}
+}
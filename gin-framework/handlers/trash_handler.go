@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"errors"
+	"gin-framework/repository"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TrashHandler serves the trash: listing, restoring and purging deleted
+// tasks
+type TrashHandler struct {
+	trash     *repository.SoftDeleteRepository
+	ids       repository.IDGenerator
+	retention time.Duration
+}
+
+func NewTrashHandler(trash *repository.SoftDeleteRepository, ids repository.IDGenerator, retention time.Duration) *TrashHandler {
+	return &TrashHandler{trash: trash, ids: ids, retention: retention}
+}
+
+// GetTrash lists the trashed tasks, most recently deleted first
+func (h *TrashHandler) GetTrash(c *gin.Context) {
+	tasks, err := h.trash.Trash(c.Request.Context())
+	if err != nil {
+		storageError(c, err, "Failed to read trash")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  tasks,
+		"count": len(tasks),
+	})
+}
+
+// RestoreTask takes a task out of the trash
+func (h *TrashHandler) RestoreTask(c *gin.Context) {
+	id, ok := taskID(c, h.ids)
+	if !ok {
+		return
+	}
+
+	task, err := h.trash.Restore(c.Request.Context(), id)
+	if errors.Is(err, repository.ErrTaskNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found in trash"})
+		return
+	}
+	if err != nil {
+		storageError(c, err, "Failed to restore task")
+		return
+	}
+
+	setETag(c, task)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Task restored successfully",
+		"data":    task,
+	})
+}
+
+// PurgeTask permanently deletes one trashed task
+func (h *TrashHandler) PurgeTask(c *gin.Context) {
+	id, ok := taskID(c, h.ids)
+	if !ok {
+		return
+	}
+
+	err := h.trash.Purge(c.Request.Context(), id)
+	if errors.Is(err, repository.ErrTaskNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found in trash"})
+		return
+	}
+	if err != nil {
+		storageError(c, err, "Failed to purge task")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Task purged permanently"})
+}
+
+// PurgeTrash permanently deletes the tasks trashed longer than the
+// retention period, or than ?older_than=<duration> when given
+func (h *TrashHandler) PurgeTrash(c *gin.Context) {
+	retention := h.retention
+	if value := c.Query("older_than"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid older_than duration"})
+			return
+		}
+		retention = d
+	}
+
+	n, err := h.trash.PurgeDeletedBefore(c.Request.Context(), time.Now().Add(-retention))
+	if err != nil {
+		storageError(c, err, "Failed to purge trash")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Trash purged",
+		"purged":  n,
+	})
+}
@@ -2,19 +2,147 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"gin-framework/config"
 	"gin-framework/database"
 	"gin-framework/handlers"
+	"gin-framework/repository"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
-	// Initialize JSON database
-	db := database.NewJSONDatabase("db.json")
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	cipher, err := newCipher(cfg)
+	if err != nil {
+		log.Fatalf("Invalid encryption key: %v", err)
+	}
+
+	if cfg.MigrateDryRun {
+		if err := printMigrationPlan(cfg.DBPath, cipher); err != nil {
+			log.Fatalf("Migration dry run failed: %v", err)
+		}
+		return
+	}
+
+	ids, err := repository.NewIDGenerator(cfg.IDStrategy)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	codec, err := database.CodecByName(cfg.Codec)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	// Open the database file before any backend loads it, so CLI commands
+	// and the integrity check get to see it as it is
+	db, err := openDatabase(cfg, cipher, codec)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	open := func() (*backend, error) {
+		return openBackend(cfg, db, ids, cipher)
+	}
+
+	if len(cfg.Command) > 0 {
+		if err := runCommand(cfg, db, ids, cipher, open, cfg.Command); err != nil {
+			log.Fatalf("%s: %v", cfg.Command[0], err)
+		}
+		return
+	}
+
+	if err := startupCheck(cfg, db, ids, cipher); err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+
+	// Initialize storage backend
+	store, err := open()
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+
+	// The full-text index and the title suggestions see every write,
+	// including replicated ones
+	search, err := repository.NewSearchIndex(store.repo)
+	if err != nil {
+		log.Fatalf("Failed to build the search index: %v", err)
+	}
+	titles, err := repository.NewTitleSuggester(search)
+	if err != nil {
+		log.Fatalf("Failed to collect title suggestions: %v", err)
+	}
+	if store.db != nil {
+		store.db.OnReload(search.RebuildLater)
+		store.db.OnReload(titles.RebuildLater)
+	}
+
+	// A leader numbers every change for its followers; a follower mirrors
+	// the leader into its own store and takes no writes
+	var changes *repository.ChangeLog
+	var follower *repository.Follower
+	var repo repository.TaskRepository = titles
+	if cfg.Follow != "" {
+		if _, ok := store.repo.(repository.Replica); !ok {
+			log.Fatalf("The %s backend cannot be used as a replica", cfg.Backend)
+		}
+		follower = repository.NewFollower(cfg.Follow, cfg.AdminToken, titles)
+		follower.Start()
+	} else {
+		changes = repository.NewChangeLog(titles, cfg.ReplicationLogSize)
+		if store.db != nil {
+			// A reload is no replayable change; followers start over
+			store.db.OnReload(changes.MarkStale)
+		}
+		repo = changes
+	}
+	// Every change is recorded as a revision, and deleted tasks go to the
+	// trash first
+	history := repository.NewHistoryRepository(repo, store.revisions)
+	if n, err := history.Reconcile(context.Background()); err != nil {
+		log.Fatalf("Failed to read task history: %v", err)
+	} else if n > 0 {
+		log.Printf("Recorded the current state of %d task(s) changed without a revision", n)
+	}
+	if store.db != nil {
+		// A restore or an external edit changes tasks without revisions
+		store.db.OnReload(history.ReconcileLater)
+		// Watched by every file backend, once all reload hooks are in
+		// place, so external, CLI and other processes' writes reach the
+		// wrappers
+		watch(cfg, store.db, store.reload)
+	}
+	trash := repository.NewSoftDeleteRepository(history)
+	// Followers get purges from the leader
+	if cfg.TrashPurgeInterval > 0 && follower == nil {
+		trash.PurgeEvery(cfg.TrashPurgeInterval, cfg.TrashRetention)
+	}
+
+	// Point-in-time snapshots of the database file; the memory backend
+	// has none
+	var snapshots *repository.Snapshotter
+	if store.db != nil {
+		snapshots = repository.NewSnapshotter(store.db, store.repo, cfg.BackupDir)
+		if cfg.BackupInterval > 0 {
+			snapshots.Every(cfg.BackupInterval, cfg.BackupKeep)
+		}
+	}
 
 	// Initialize handlers
-	taskHandler := handlers.NewTaskHandler(db)
+	taskHandler := handlers.NewTaskHandler(trash, history, ids)
+	trashHandler := handlers.NewTrashHandler(trash, ids, cfg.TrashRetention)
+	adminHandler := handlers.NewAdminHandler(snapshots)
+	replicationHandler := handlers.NewReplicationHandler(changes, follower)
+	searchHandler := handlers.NewSearchHandler(search)
+	suggestHandler := handlers.NewSuggestHandler(titles)
 
 	// Setup Gin router with logger & recovery middleware
 	router := gin.Default()
@@ -26,11 +154,34 @@ func main() {
 			"version": "1.0.0",
 			"endpoints": gin.H{
 				"tasks": gin.H{
-					"GET /api/tasks":     "Get all tasks",
-					"GET /api/tasks/:id": "Get task by ID",
-					"POST /api/tasks":    "Create new task",
-					"PUT /api/tasks/:id": "Update task",
-					"DELETE /api/tasks/:id": "Delete task",
+					"GET /api/tasks":              "Get all tasks",
+					"GET /api/tasks/export":       "Export all tasks as NDJSON",
+					"GET /api/tasks/suggest":      "Suggest existing titles for a prefix",
+					"GET /api/tasks/:id":          "Get task by ID",
+					"POST /api/tasks":             "Create new task",
+					"PUT /api/tasks/:id":          "Update task",
+					"DELETE /api/tasks/:id":       "Move task to trash",
+					"POST /api/tasks/:id/restore": "Restore task from trash",
+					"GET /api/tasks/:id/history":  "Get task revisions",
+					"POST /api/tasks/:id/revert":  "Revert task to a revision",
+				},
+				"search": gin.H{
+					"GET /api/search?q=": "Full-text search in titles and descriptions",
+				},
+				"admin": gin.H{
+					"GET /api/admin/snapshots":                "List database snapshots",
+					"POST /api/admin/snapshots":               "Create a database snapshot",
+					"POST /api/admin/snapshots/:name/restore": "Restore a database snapshot",
+				},
+				"replication": gin.H{
+					"GET /api/replication/status":   "Replication role and position",
+					"GET /api/replication/snapshot": "All tasks with the change log position (leader)",
+					"GET /api/replication/changes":  "Stream changes since a sequence number (leader)",
+				},
+				"trash": gin.H{
+					"GET /api/trash":        "Get trashed tasks",
+					"DELETE /api/trash":     "Purge tasks past the retention period",
+					"DELETE /api/trash/:id": "Purge one trashed task",
 				},
 			},
 		})
@@ -38,18 +189,187 @@ func main() {
 
 	// API routes group
 	api := router.Group("/api")
+	api.Use(handlers.Author())
+	if follower != nil {
+		api.Use(handlers.ReadOnly(cfg.Follow))
+	}
 	{
 		// Task routes
 		tasks := api.Group("/tasks")
 		{
 			tasks.GET("", taskHandler.GetAllTasks)
+			tasks.GET("/export", taskHandler.ExportTasks)
+			tasks.GET("/suggest", suggestHandler.Suggest)
 			tasks.GET("/:id", taskHandler.GetTaskByID)
 			tasks.POST("", taskHandler.CreateTask)
 			tasks.PUT("/:id", taskHandler.UpdateTask)
 			tasks.DELETE("/:id", taskHandler.DeleteTask)
+			tasks.POST("/:id/restore", trashHandler.RestoreTask)
+			tasks.GET("/:id/history", taskHandler.GetTaskHistory)
+			tasks.POST("/:id/revert", taskHandler.RevertTask)
+		}
+
+		api.GET("/search", searchHandler.Search)
+
+		// Trash routes
+		trashRoutes := api.Group("/trash")
+		{
+			trashRoutes.GET("", trashHandler.GetTrash)
+			trashRoutes.DELETE("", trashHandler.PurgeTrash)
+			trashRoutes.DELETE("/:id", trashHandler.PurgeTask)
+		}
+
+		// Admin routes, protected by ADMIN_TOKEN when it is set
+		admin := api.Group("/admin", handlers.AdminToken(cfg.AdminToken))
+		{
+			admin.GET("/snapshots", adminHandler.ListSnapshots)
+			admin.POST("/snapshots", adminHandler.CreateSnapshot)
+			admin.POST("/snapshots/:name/restore", adminHandler.RestoreSnapshot)
+		}
+
+		// Replication routes, protected by ADMIN_TOKEN like the admin ones
+		replication := api.Group("/replication", handlers.AdminToken(cfg.AdminToken))
+		{
+			replication.GET("/status", replicationHandler.Status)
+			replication.GET("/snapshot", replicationHandler.Snapshot)
+			replication.GET("/changes", replicationHandler.Changes)
 		}
 	}
 
 	// Start server
-	router.Run(":8080") // Listen on port 8080
+	router.Run(cfg.Addr) // Listen on :8080 by default
+}
+
+// backend is the opened storage
+type backend struct {
+	repo      repository.TaskRepository
+	revisions repository.RevisionStore
+	db        *database.JSONDatabase // nil for the memory backend
+	reload    database.ReloadFunc    // picks up external changes to db
+}
+
+// openDatabase opens the database file, or returns nil for the memory
+// backend
+func openDatabase(cfg *config.Config, cipher *database.Cipher, codec database.Codec) (*database.JSONDatabase, error) {
+	if cfg.Backend == config.BackendMemory {
+		return nil, nil
+	}
+
+	db, err := database.Open(cfg.DBPath, &database.Options{LockTimeout: cfg.LockTimeout, Cipher: cipher, Codec: codec})
+	if err != nil {
+		return nil, err
+	}
+	if db.Created() {
+		log.Printf("Created new database at %s", db.Path())
+	}
+	return db, nil
+}
+
+// openBackend opens the storage backend selected in the config on top of
+// db and the revision store kept next to it
+func openBackend(cfg *config.Config, db *database.JSONDatabase, ids repository.IDGenerator, cipher *database.Cipher) (*backend, error) {
+	if db == nil {
+		return &backend{repo: repository.NewMemoryTaskRepository(ids), revisions: repository.NewMemoryRevisionStore()}, nil
+	}
+
+	revisions, err := repository.NewJournalRevisionStore(db, cfg.RevisionsPath)
+	if err != nil {
+		return nil, err
+	}
+	b := &backend{revisions: revisions, db: db}
+
+	switch cfg.Backend {
+	case config.BackendWAL:
+		wal, err := database.OpenLog(cfg.WALPath, cipher)
+		if err != nil {
+			return nil, err
+		}
+		repo, err := repository.NewWALTaskRepository(db, wal, ids, cfg.WALCompactSize)
+		if err != nil {
+			return nil, err
+		}
+		b.repo, b.reload = repo, repo.Reload
+	case config.BackendIndexed:
+		repo, err := repository.NewIndexedTaskRepository(db, ids)
+		if err != nil {
+			return nil, err
+		}
+		b.repo, b.reload = repo, repo.Reload
+	default:
+		// Reads the file on every call, so external edits are always seen
+		repo := repository.NewJSONTaskRepository(db, ids)
+		b.repo, b.reload = repo, repo.Reload
+	}
+	return b, nil
+}
+
+// newCipher builds the encryption-at-rest cipher from the configured keys,
+// or returns nil when no key is configured
+func newCipher(cfg *config.Config) (*database.Cipher, error) {
+	var keys []string
+	switch {
+	case cfg.EncryptionKeyFile != "":
+		data, err := ioutil.ReadFile(cfg.EncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				keys = append(keys, line)
+			}
+		}
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("%s holds no key", cfg.EncryptionKeyFile)
+		}
+	case cfg.EncryptionKey != "":
+		keys = []string{cfg.EncryptionKey}
+	default:
+		return nil, nil
+	}
+	if cfg.OldEncryptionKeys != "" {
+		keys = append(keys, strings.Split(cfg.OldEncryptionKeys, ",")...)
+	}
+
+	parsed := make([][]byte, len(keys))
+	for i, key := range keys {
+		var err error
+		if parsed[i], err = database.ParseKey(key); err != nil {
+			return nil, err
+		}
+	}
+	return database.NewCipher(parsed[0], parsed[1:]...)
+}
+
+// watch reloads cached tasks when db.json is changed outside the server,
+// by hand, by a CLI command or by another process sharing the file
+func watch(cfg *config.Config, db *database.JSONDatabase, reload database.ReloadFunc) {
+	if cfg.WatchInterval > 0 {
+		db.Watch(cfg.WatchInterval, reload)
+	}
+}
+
+// printMigrationPlan shows which schema migrations Open would apply to the
+// database file and what they would change, without writing anything
+func printMigrationPlan(path string, cipher *database.Cipher) error {
+	from, steps, err := database.PlanMigrations(path, cipher)
+	if err != nil {
+		return err
+	}
+
+	if len(steps) == 0 {
+		fmt.Printf("%s is at schema version %d, nothing to migrate\n", path, from)
+		return nil
+	}
+
+	fmt.Printf("%s is at schema version %d, %d migration(s) pending:\n", path, from, len(steps))
+	for _, step := range steps {
+		fmt.Printf("  -> %d: %s\n", step.Version, step.Description)
+		if len(step.Changes) == 0 {
+			fmt.Println("       no changes to collections")
+		}
+		for _, change := range step.Changes {
+			fmt.Printf("       %s\n", change)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Change operations
+const (
+	ChangePut    = "put"    // the task was created or changed; Task holds its new state
+	ChangeDelete = "delete" // the task was removed for good
+)
+
+// Change is one committed mutation in the replication change log. Seq
+// increases by one per change within an epoch.
+type Change struct {
+	Seq       uint64    `json:"seq"`
+	Op        string    `json:"op"`
+	TaskID    TaskID    `json:"id"`
+	Task      *Task     `json:"task,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ReplicaSnapshot is the full state a follower starts from: every task,
+// trashed ones included, as of change Seq in Epoch
+type ReplicaSnapshot struct {
+	Epoch string `json:"epoch"`
+	Seq   uint64 `json:"seq"`
+	Tasks []Task `json:"tasks"`
+}
@@ -0,0 +1,75 @@
+package models
+
+import "time"
+
+// Revision actions
+const (
+	ActionImport  = "import" // state found when history started for an existing task
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete" // moved to the trash
+	ActionRestore = "restore"
+	ActionRevert  = "revert"
+	ActionPurge   = "purge"
+)
+
+// Revision records one mutation of a task: who made it, when, which fields
+// changed and the full task as it was afterwards
+type Revision struct {
+	TaskID    TaskID        `json:"task_id"`
+	Number    int           `json:"revision"`
+	Action    string        `json:"action"`
+	Author    string        `json:"author"`
+	Timestamp time.Time     `json:"timestamp"`
+	Changes   []FieldChange `json:"changes"`
+	// RevertedTo is the revision whose content a revert brought back
+	RevertedTo int  `json:"reverted_to,omitempty"`
+	Task       Task `json:"task"`
+}
+
+// FieldChange is the old and new value of one task field
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// DiffTasks lists the fields that differ between two states of a task
+func DiffTasks(before, after Task) []FieldChange {
+	changes := []FieldChange{}
+	if before.Title != after.Title {
+		changes = append(changes, FieldChange{Field: "title", From: before.Title, To: after.Title})
+	}
+	if before.Description != after.Description {
+		changes = append(changes, FieldChange{Field: "description", From: before.Description, To: after.Description})
+	}
+	if before.Completed != after.Completed {
+		changes = append(changes, FieldChange{Field: "completed", From: before.Completed, To: after.Completed})
+	}
+	if !sameTags(before.Tags, after.Tags) {
+		changes = append(changes, FieldChange{Field: "tags", From: before.Tags, To: after.Tags})
+	}
+	if !sameTime(before.DeletedAt, after.DeletedAt) {
+		changes = append(changes, FieldChange{Field: "deleted_at", From: before.DeletedAt, To: after.DeletedAt})
+	}
+	return changes
+}
+
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
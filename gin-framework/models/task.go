@@ -1,23 +1,66 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
 
 type Task struct {
-	ID          int       `json:"id"`
+	ID          TaskID    `json:"id"`
+	Version     int64     `json:"version"` // incremented by every write
 	Title       string    `json:"title" binding:"required"`
 	Description string    `json:"description"`
 	Completed   bool      `json:"completed"`
+	Tags        []string  `json:"tags,omitempty"` // see NormalizeTags
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	// DeletedAt is set while the task is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 type CreateTaskInput struct {
-	Title       string `json:"title" binding:"required"`
-	Description string `json:"description"`
+	Title       string   `json:"title" binding:"required"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
 }
 
 type UpdateTaskInput struct {
-	Title       *string `json:"title"`
-	Description *string `json:"description"`
-	Completed   *bool   `json:"completed"`
+	Title       *string   `json:"title"`
+	Description *string   `json:"description"`
+	Completed   *bool     `json:"completed"`
+	Tags        *[]string `json:"tags"` // replaces all tags
+}
+
+// NormalizeTags lowercases and trims tags and drops repeats, keeping the
+// first occurrence. A tag must be a single word: empty tags and tags with
+// spaces, quotes or parentheses, which a query could not name without
+// quoting, are rejected.
+func NormalizeTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	out := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			return nil, fmt.Errorf("empty tag")
+		}
+		if i := strings.IndexFunc(tag, func(r rune) bool {
+			return unicode.IsSpace(r) || r == '"' || r == '(' || r == ')'
+		}); i >= 0 {
+			return nil, fmt.Errorf("tag %q contains %q", tag, tag[i:i+1])
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			out = append(out, tag)
+		}
+	}
+	return out, nil
+}
+
+type RevertTaskInput struct {
+	Revision int `json:"revision" binding:"required"`
 }
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// TaskID identifies a task. Sequence IDs are decimal numbers and are
+// written as JSON numbers, as they always have been; ULID and UUIDv7 IDs
+// are written as strings.
+type TaskID string
+
+// IsNumeric reports whether id is a sequence number
+func (id TaskID) IsNumeric() bool {
+	if id == "" || (len(id) > 1 && id[0] == '0') {
+		return false
+	}
+	for _, c := range id {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Number returns the value of a sequence ID, or 0 for other IDs
+func (id TaskID) Number() uint64 {
+	if !id.IsNumeric() {
+		return 0
+	}
+	n, err := strconv.ParseUint(string(id), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// Less orders sequence IDs numerically and before every other ID; other
+// IDs compare as strings, which for ULID and UUIDv7 is creation order
+func (id TaskID) Less(other TaskID) bool {
+	a, b := id.IsNumeric(), other.IsNumeric()
+	switch {
+	case a && b:
+		if len(id) != len(other) {
+			return len(id) < len(other)
+		}
+		return id < other
+	case a != b:
+		return a
+	default:
+		return id < other
+	}
+}
+
+func (id TaskID) String() string {
+	return string(id)
+}
+
+func (id TaskID) MarshalJSON() ([]byte, error) {
+	if id.IsNumeric() {
+		return []byte(id), nil
+	}
+	return json.Marshal(string(id))
+}
+
+func (id *TaskID) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*id = TaskID(s)
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	if _, err := strconv.ParseUint(n.String(), 10, 64); err != nil {
+		return errors.New("task id must be a positive integer or a string")
+	}
+	*id = TaskID(n.String())
+	return nil
+}
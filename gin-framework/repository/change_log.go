@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"gin-framework/models"
+	"sync"
+	"time"
+)
+
+// ErrChangesGone is returned when the requested changes are no longer
+// kept or belong to another epoch; the follower has to start over from a
+// snapshot
+var ErrChangesGone = errors.New("changes no longer available")
+
+// ChangeLog wraps a backend and numbers every committed mutation, so
+// followers can replicate the store by replaying them. Only the recent
+// changes are kept, in memory. The epoch names one unbroken sequence: it
+// is new on every start and whenever the store is reloaded from disk,
+// which tells followers to take a new snapshot.
+type ChangeLog struct {
+	*observedRepository
+	size int
+
+	// mu is never held while calling into the backend, so it can be taken
+	// from database reload hooks
+	mu      sync.Mutex
+	epoch   string
+	seq     uint64
+	changes []models.Change // the latest, oldest first
+	notify  chan struct{}   // closed and replaced on every change
+}
+
+// NewChangeLog keeps at least the last size changes of repo
+func NewChangeLog(repo TaskRepository, size int) *ChangeLog {
+	c := &ChangeLog{size: size, epoch: newEpoch(), notify: make(chan struct{})}
+	c.observedRepository = newObservedRepository(repo, writeHooks{put: c.put, remove: c.remove, replace: c.replaced})
+	return c
+}
+
+func newEpoch() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Position returns the current epoch and the sequence number of the last
+// change
+func (c *ChangeLog) Position() (epoch string, seq uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch, c.seq
+}
+
+// Since returns the changes after seq in epoch, and a channel that is
+// closed once there are newer ones or the epoch ends
+func (c *ChangeLog) Since(epoch string, seq uint64) ([]models.Change, <-chan struct{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	oldest := c.seq - uint64(len(c.changes)) // changes cover (oldest, c.seq]
+	if epoch != c.epoch || seq > c.seq || seq < oldest {
+		return nil, nil, ErrChangesGone
+	}
+
+	changes := make([]models.Change, c.seq-seq)
+	copy(changes, c.changes[seq-oldest:])
+	return changes, c.notify, nil
+}
+
+// Snapshot returns every task with the position it corresponds to
+func (c *ChangeLog) Snapshot(ctx context.Context) (*models.ReplicaSnapshot, error) {
+	var snapshot *models.ReplicaSnapshot
+	err := c.exclusive(func() error {
+		tasks, err := c.repo.List(ctx)
+		if err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		snapshot = &models.ReplicaSnapshot{Epoch: c.epoch, Seq: c.seq, Tasks: tasks}
+		return nil
+	})
+	return snapshot, err
+}
+
+// MarkStale starts a new epoch after the store was reloaded from disk,
+// since the reload is not a change followers could replay. It is safe to
+// call from a database reload hook.
+func (c *ChangeLog) MarkStale() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.restart()
+}
+
+// restart starts a new, empty epoch and wakes the open streams; callers
+// must hold c.mu
+func (c *ChangeLog) restart() {
+	c.epoch, c.seq, c.changes = newEpoch(), 0, nil
+	close(c.notify)
+	c.notify = make(chan struct{})
+}
+
+// put, remove and replaced are the write hooks
+func (c *ChangeLog) put(task models.Task) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record(models.ChangePut, task.ID, &task)
+}
+
+func (c *ChangeLog) remove(id models.TaskID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record(models.ChangeDelete, id, nil)
+}
+
+// replaced is no change followers could replay either
+func (c *ChangeLog) replaced(tasks []models.Task) {
+	c.MarkStale()
+}
+
+// record appends a change; callers must hold c.mu
+func (c *ChangeLog) record(op string, id models.TaskID, task *models.Task) {
+	c.seq++
+	c.changes = append(c.changes, models.Change{Seq: c.seq, Op: op, TaskID: id, Task: task, Timestamp: time.Now()})
+	// Trim in batches so appending stays cheap
+	if len(c.changes) >= 2*c.size {
+		c.changes = append([]models.Change(nil), c.changes[len(c.changes)-c.size:]...)
+	}
+
+	close(c.notify)
+	c.notify = make(chan struct{})
+}
@@ -0,0 +1,328 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gin-framework/models"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Replica is a backend a follower mirrors the leader into. Unlike Create
+// and Update, its methods store tasks exactly as given, keeping the
+// leader's IDs and versions.
+type Replica interface {
+	TaskRepository
+	// ReplaceAll replaces every task with tasks
+	ReplaceAll(ctx context.Context, tasks []models.Task) error
+	// Apply stores one change from the leader's change log
+	Apply(ctx context.Context, change models.Change) error
+}
+
+// asReplica returns repo as a Replica, for wrappers that pass replicated
+// writes through to the backend below them
+func asReplica(repo TaskRepository) (Replica, error) {
+	replica, ok := repo.(Replica)
+	if !ok {
+		return nil, errors.New("backend cannot be used as a replica")
+	}
+	return replica, nil
+}
+
+// Replication endpoints on the leader, relative to its base URL
+const (
+	ReplicationSnapshotPath = "/api/replication/snapshot"
+	ReplicationChangesPath  = "/api/replication/changes"
+)
+
+// ReplicationHeartbeat is how often the leader writes an empty line to an
+// idle change stream, so followers notice a dead connection
+const ReplicationHeartbeat = 15 * time.Second
+
+// errResync makes the follower start over from a snapshot
+var errResync = errors.New("replica must be resynchronized")
+
+func checkChange(change models.Change) error {
+	switch change.Op {
+	case models.ChangePut:
+		if change.Task == nil || change.Task.ID != change.TaskID {
+			return fmt.Errorf("change %d: put needs the task with id %s", change.Seq, change.TaskID)
+		}
+	case models.ChangeDelete:
+	default:
+		return fmt.Errorf("change %d: unknown operation %q", change.Seq, change.Op)
+	}
+	return nil
+}
+
+// applyChange applies a checked change to an in-memory index
+func applyChange(index *taskIndex, seq *uint64, change models.Change) {
+	if change.Op == models.ChangeDelete {
+		index.remove(change.TaskID)
+		return
+	}
+	index.put(*change.Task)
+	seqAtLeast(seq, change.TaskID)
+}
+
+// applyToSlice applies a checked change to tasks kept in file order
+func applyToSlice(tasks []models.Task, change models.Change) []models.Task {
+	for i := range tasks {
+		if tasks[i].ID != change.TaskID {
+			continue
+		}
+		if change.Op == models.ChangeDelete {
+			return append(tasks[:i], tasks[i+1:]...)
+		}
+		tasks[i] = *change.Task
+		return tasks
+	}
+	if change.Op == models.ChangePut {
+		tasks = append(tasks, *change.Task)
+	}
+	return tasks
+}
+
+// Follower keeps a Replica in step with a leader: it bootstraps from the
+// leader's snapshot and then tails its change log, starting over from a
+// new snapshot whenever the log cannot be continued.
+type Follower struct {
+	leader  string
+	token   string
+	replica Replica
+	client  *http.Client
+
+	mu          sync.Mutex
+	epoch       string // empty until bootstrapped
+	seq         uint64
+	lastContact time.Time
+	lastError   string
+}
+
+// FollowerStatus reports how far a follower has got
+type FollowerStatus struct {
+	Leader      string     `json:"leader"`
+	Epoch       string     `json:"epoch"`
+	Seq         uint64     `json:"seq"`
+	LastContact *time.Time `json:"last_contact,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// NewFollower replicates the leader at the base URL leader into replica.
+// token, when set, is sent as a bearer token.
+func NewFollower(leader, token string, replica Replica) *Follower {
+	return &Follower{
+		leader:  strings.TrimRight(leader, "/"),
+		token:   token,
+		replica: replica,
+		client:  &http.Client{},
+	}
+}
+
+// Leader returns the base URL of the leader
+func (f *Follower) Leader() string {
+	return f.leader
+}
+
+func (f *Follower) Status() FollowerStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status := FollowerStatus{Leader: f.leader, Epoch: f.epoch, Seq: f.seq, LastError: f.lastError}
+	if !f.lastContact.IsZero() {
+		contact := f.lastContact
+		status.LastContact = &contact
+	}
+	return status
+}
+
+// Start replicates in the background, retrying with backoff while the
+// leader is unreachable. The returned function stops it.
+func (f *Follower) Start() (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		backoff := time.Second
+		for ctx.Err() == nil {
+			err := f.sync(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err == errResync {
+				log.Printf("Replication from %s must start over, taking a new snapshot", f.leader)
+				continue
+			}
+
+			f.mu.Lock()
+			f.lastError = err.Error()
+			f.mu.Unlock()
+			log.Printf("ERROR: replicating from %s: %v (retrying in %s)", f.leader, err, backoff)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+		}
+	}()
+
+	return cancel
+}
+
+// sync bootstraps if needed and then tails the change log until the
+// stream ends
+func (f *Follower) sync(ctx context.Context) error {
+	f.mu.Lock()
+	epoch, seq := f.epoch, f.seq
+	f.mu.Unlock()
+
+	if epoch == "" {
+		if err := f.bootstrap(ctx); err != nil {
+			return err
+		}
+		f.mu.Lock()
+		epoch, seq = f.epoch, f.seq
+		f.mu.Unlock()
+	}
+	return f.tail(ctx, epoch, seq)
+}
+
+func (f *Follower) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := f.leader + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if f.token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.token)
+	}
+	return f.client.Do(req)
+}
+
+func (f *Follower) bootstrap(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	resp, err := f.get(ctx, ReplicationSnapshotPath, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return leaderError(resp)
+	}
+
+	var snapshot models.ReplicaSnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+		return fmt.Errorf("reading snapshot: %w", err)
+	}
+	if err := f.replica.ReplaceAll(ctx, snapshot.Tasks); err != nil {
+		return fmt.Errorf("loading snapshot: %w", err)
+	}
+
+	f.mu.Lock()
+	f.epoch, f.seq = snapshot.Epoch, snapshot.Seq
+	f.lastContact, f.lastError = time.Now(), ""
+	f.mu.Unlock()
+	log.Printf("Replica loaded %d task(s) from %s at epoch %s, change %d", len(snapshot.Tasks), f.leader, snapshot.Epoch, snapshot.Seq)
+	return nil
+}
+
+// tail applies the change stream after seq until it ends or fails
+func (f *Follower) tail(ctx context.Context, epoch string, seq uint64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := url.Values{"epoch": {epoch}, "since": {strconv.FormatUint(seq, 10)}, "follow": {"true"}}
+	resp, err := f.get(ctx, ReplicationChangesPath, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusGone:
+		f.resync()
+		return errResync
+	default:
+		return leaderError(resp)
+	}
+
+	// The leader sends at least a heartbeat every ReplicationHeartbeat;
+	// a silent connection is dead
+	idle := time.AfterFunc(3*ReplicationHeartbeat, cancel)
+	defer idle.Stop()
+
+	r := bufio.NewReader(resp.Body)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(bytes.TrimSpace(line)) == 0 {
+			return errors.New("change stream closed by the leader")
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		idle.Reset(3 * ReplicationHeartbeat)
+
+		f.mu.Lock()
+		f.lastContact = time.Now()
+		f.mu.Unlock()
+		if line = bytes.TrimSpace(line); len(line) == 0 {
+			continue
+		}
+
+		var change models.Change
+		if err := json.Unmarshal(line, &change); err != nil {
+			return fmt.Errorf("reading change: %w", err)
+		}
+		if change.Seq != seq+1 {
+			f.resync()
+			return errResync
+		}
+		if err := f.replica.Apply(ctx, change); err != nil {
+			return fmt.Errorf("applying change %d: %w", change.Seq, err)
+		}
+
+		seq = change.Seq
+		f.mu.Lock()
+		f.seq, f.lastError = seq, ""
+		f.mu.Unlock()
+	}
+}
+
+// resync forgets the position, so the next sync takes a new snapshot
+func (f *Follower) resync() {
+	f.mu.Lock()
+	f.epoch, f.seq = "", 0
+	f.mu.Unlock()
+}
+
+// leaderError describes an unexpected answer from the leader
+func leaderError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	var answer struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &answer) == nil && answer.Error != "" {
+		return fmt.Errorf("leader answered %s: %s", resp.Status, answer.Error)
+	}
+	return fmt.Errorf("leader answered %s", resp.Status)
+}
@@ -0,0 +1,379 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"gin-framework/models"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrRevisionNotFound is returned when a task has no revision with the
+// requested number
+var ErrRevisionNotFound = errors.New("revision not found")
+
+type authorKey struct{}
+
+// WithAuthor tags ctx with the user making a change, for the revision log
+func WithAuthor(ctx context.Context, author string) context.Context {
+	return context.WithValue(ctx, authorKey{}, author)
+}
+
+func authorFrom(ctx context.Context) string {
+	author, _ := ctx.Value(authorKey{}).(string)
+	return author
+}
+
+// HistoryRepository wraps a backend and records a revision for every
+// mutation in a RevisionStore, so past states of a task can be listed,
+// read back and reverted to.
+//
+// The revision is written after the backend commits the change. Writes
+// are serialized so revisions are numbered in the order the changes were
+// applied. A revision that cannot be stored is kept and retried before the
+// next one; one lost with the process is made up for by Reconcile.
+type HistoryRepository struct {
+	repo      TaskRepository
+	revisions RevisionStore
+
+	mu      sync.Mutex
+	pending []models.Revision // not stored yet, oldest first
+}
+
+func NewHistoryRepository(repo TaskRepository, revisions RevisionStore) *HistoryRepository {
+	return &HistoryRepository{repo: repo, revisions: revisions}
+}
+
+// record appends one revision. A failure is logged rather than returned:
+// the change itself is already saved and must not be reported as failed.
+func (r *HistoryRepository) record(ctx context.Context, action string, before, after models.Task) {
+	rev := models.Revision{
+		TaskID:    after.ID,
+		Action:    action,
+		Author:    authorFrom(ctx),
+		Timestamp: time.Now(),
+		Changes:   models.DiffTasks(before, after),
+		Task:      after,
+	}
+	r.append(&rev)
+}
+
+// append stores rev after any revisions still pending; callers must hold
+// r.mu
+func (r *HistoryRepository) append(rev *models.Revision) {
+	r.pending = append(r.pending, *rev)
+	for len(r.pending) > 0 {
+		// The change is done; record it even if the request was cancelled
+		if err := r.revisions.Append(context.Background(), &r.pending[0]); err != nil {
+			log.Printf("ERROR: recording revision of task %s, %d revision(s) pending: %v", r.pending[0].TaskID, len(r.pending), err)
+			return
+		}
+		r.pending = r.pending[1:]
+	}
+}
+
+// Reconcile records the current state of every task its history lags
+// behind, as an import revision, and a purge for every task its history
+// has that is gone: after a crash between a change and its revision, or a
+// change made without the server, such as a repair or a snapshot restore.
+// It returns how many tasks it caught up.
+func (r *HistoryRepository) Reconcile(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var behind []models.Task
+	present := map[models.TaskID]bool{}
+	var listErr error
+	err := r.repo.Scan(ctx, func(task models.Task) bool {
+		present[task.ID] = true
+		revs, err := r.revisions.List(ctx, task.ID)
+		if err != nil {
+			listErr = err
+			return false
+		}
+		if len(revs) > 0 && revs[len(revs)-1].Task.Version != task.Version {
+			behind = append(behind, task)
+		}
+		return true
+	})
+	if err == nil {
+		err = listErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	ids, err := r.revisions.Tasks(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var gone []models.Revision
+	for _, id := range ids {
+		if present[id] {
+			continue
+		}
+		revs, err := r.revisions.List(ctx, id)
+		if err != nil {
+			return 0, err
+		}
+		if last := revs[len(revs)-1]; last.Action != models.ActionPurge {
+			gone = append(gone, last)
+		}
+	}
+
+	for _, task := range behind {
+		r.append(&models.Revision{
+			TaskID:    task.ID,
+			Action:    models.ActionImport,
+			Timestamp: time.Now(),
+			Changes:   []models.FieldChange{},
+			Task:      task,
+		})
+	}
+	for _, last := range gone {
+		purged := models.Task{ID: last.TaskID}
+		r.append(&models.Revision{
+			TaskID:    last.TaskID,
+			Action:    models.ActionPurge,
+			Timestamp: time.Now(),
+			Changes:   models.DiffTasks(last.Task, purged),
+			Task:      purged,
+		})
+	}
+	return len(behind) + len(gone), nil
+}
+
+// ReconcileLater reconciles in the background; use it from database
+// reload hooks, which run under the database lock Reconcile needs
+func (r *HistoryRepository) ReconcileLater() {
+	go func() {
+		n, err := r.Reconcile(context.Background())
+		if err != nil {
+			log.Printf("ERROR: reconciling task history: %v", err)
+		} else if n > 0 {
+			log.Printf("Recorded the current state of %d task(s) changed without a revision", n)
+		}
+	}()
+}
+
+// importBaseline records the current state of a task its history does not
+// end in, so its next recorded change has something to diff from: one
+// that predates the revision log, or one changed without a revision and
+// not reconciled yet
+func (r *HistoryRepository) importBaseline(ctx context.Context, task models.Task) {
+	revs, err := r.revisions.List(ctx, task.ID)
+	if err != nil {
+		return
+	}
+	at := task.UpdatedAt
+	if len(revs) > 0 {
+		if revs[len(revs)-1].Task.Version == task.Version {
+			return
+		}
+		// Revisions stay in time order
+		at = time.Now()
+	}
+
+	r.append(&models.Revision{
+		TaskID:    task.ID,
+		Action:    models.ActionImport,
+		Timestamp: at,
+		Changes:   []models.FieldChange{},
+		Task:      task,
+	})
+}
+
+func (r *HistoryRepository) Get(ctx context.Context, id models.TaskID) (*models.Task, error) {
+	return r.repo.Get(ctx, id)
+}
+
+func (r *HistoryRepository) List(ctx context.Context) ([]models.Task, error) {
+	return r.repo.List(ctx)
+}
+
+func (r *HistoryRepository) Scan(ctx context.Context, fn func(task models.Task) bool) error {
+	return r.repo.Scan(ctx, fn)
+}
+
+func (r *HistoryRepository) Create(ctx context.Context, task *models.Task) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.repo.Create(ctx, task); err != nil {
+		return err
+	}
+	r.record(ctx, models.ActionCreate, models.Task{}, *task)
+	return nil
+}
+
+// Update records the change as an update, or as a delete or restore when
+// it moves the task in or out of the trash
+func (r *HistoryRepository) Update(ctx context.Context, id models.TaskID, fn func(task *models.Task) error) (*models.Task, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var before models.Task
+	updated, err := r.repo.Update(ctx, id, func(task *models.Task) error {
+		before = *task
+		return fn(task)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	action := models.ActionUpdate
+	switch {
+	case before.DeletedAt == nil && updated.DeletedAt != nil:
+		action = models.ActionDelete
+	case before.DeletedAt != nil && updated.DeletedAt == nil:
+		action = models.ActionRestore
+	}
+
+	r.importBaseline(ctx, before)
+	r.record(ctx, action, before, *updated)
+	return updated, nil
+}
+
+func (r *HistoryRepository) Delete(ctx context.Context, id models.TaskID) error {
+	n, err := r.DeleteMatching(ctx, func(task models.Task) bool { return task.ID == id })
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTaskNotFound
+	}
+	return nil
+}
+
+// DeleteMatching records a purge revision for each removed task; their
+// history stays readable afterwards
+func (r *HistoryRepository) DeleteMatching(ctx context.Context, match func(task models.Task) bool) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var removed []models.Task
+	n, err := r.repo.DeleteMatching(ctx, func(task models.Task) bool {
+		if !match(task) {
+			return false
+		}
+		removed = append(removed, task)
+		return true
+	})
+	if err != nil {
+		return n, err
+	}
+
+	for _, task := range removed {
+		r.importBaseline(ctx, task)
+		r.record(ctx, models.ActionPurge, task, models.Task{ID: task.ID})
+	}
+	return n, nil
+}
+
+// History returns the revisions of a task oldest first. A task that has
+// not changed since before the revision log existed has none.
+func (r *HistoryRepository) History(ctx context.Context, id models.TaskID) ([]models.Revision, error) {
+	revs, err := r.revisions.List(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(revs) > 0 {
+		return revs, nil
+	}
+
+	// Tell an unchanged task apart from one that never existed
+	if _, err := r.repo.Get(ctx, id); err != nil {
+		return nil, err
+	}
+	return []models.Revision{}, nil
+}
+
+// GetAsOf reconstructs the task as it was at the given time. It returns
+// ErrTaskNotFound when the task did not exist yet, was in the trash or had
+// been purged at that time.
+func (r *HistoryRepository) GetAsOf(ctx context.Context, id models.TaskID, at time.Time) (*models.Task, error) {
+	revs, err := r.revisions.List(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var task *models.Task
+	switch {
+	case len(revs) == 0:
+		// Unchanged since before the revision log; the current state is
+		// the only one known
+		current, err := r.repo.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		task = current
+	case at.Before(revs[0].Timestamp):
+		if revs[0].Action == models.ActionImport {
+			// The imported state is the oldest one known
+			task = &revs[0].Task
+		}
+	default:
+		for i := range revs {
+			if revs[i].Timestamp.After(at) {
+				break
+			}
+			if revs[i].Action == models.ActionPurge {
+				task = nil
+				continue
+			}
+			task = &revs[i].Task
+		}
+	}
+
+	if task == nil || task.CreatedAt.After(at) || (task.DeletedAt != nil && !task.DeletedAt.After(at)) {
+		return nil, ErrTaskNotFound
+	}
+	return task, nil
+}
+
+// Revert brings the title, description and completed status of a task
+// back to what they were at the given revision. Trashed tasks must be
+// restored first.
+func (r *HistoryRepository) Revert(ctx context.Context, id models.TaskID, number int) (*models.Task, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	revs, err := r.revisions.List(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if number < 1 || number > len(revs) || revs[number-1].Action == models.ActionPurge {
+		return nil, ErrRevisionNotFound
+	}
+	target := revs[number-1].Task
+
+	var before models.Task
+	updated, err := r.repo.Update(ctx, id, func(task *models.Task) error {
+		if task.DeletedAt != nil {
+			return ErrTaskNotFound
+		}
+		before = *task
+		task.Title = target.Title
+		task.Description = target.Description
+		task.Completed = target.Completed
+		task.Tags = target.Tags
+		task.UpdatedAt = time.Now()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rev := models.Revision{
+		TaskID:     id,
+		Action:     models.ActionRevert,
+		Author:     authorFrom(ctx),
+		Timestamp:  time.Now(),
+		Changes:    models.DiffTasks(before, *updated),
+		RevertedTo: number,
+		Task:       *updated,
+	}
+	r.append(&rev)
+	return updated, nil
+}
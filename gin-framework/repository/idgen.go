@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gin-framework/models"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ID strategies understood by NewIDGenerator
+const (
+	IDSequence = "sequence"
+	IDULID     = "ulid"
+	IDUUIDv7   = "uuidv7"
+)
+
+// IDStrategies lists the strategy names NewIDGenerator accepts
+var IDStrategies = []string{IDSequence, IDULID, IDUUIDv7}
+
+// ErrInvalidID is returned by Parse for an ID the generator cannot have made
+var ErrInvalidID = errors.New("invalid task ID")
+
+// IDGenerator hands out task IDs that are never reused, even after the
+// newest task is deleted
+type IDGenerator interface {
+	// Next returns a new ID. seq is the sequence stored next to the tasks;
+	// generators that count advance it and the repository persists it in
+	// the same write as the new task.
+	Next(seq *uint64) (models.TaskID, error)
+	// Parse validates an ID taken from a URL
+	Parse(s string) (models.TaskID, error)
+}
+
+// NewIDGenerator returns the generator for a strategy name
+func NewIDGenerator(strategy string) (IDGenerator, error) {
+	switch strategy {
+	case IDSequence:
+		return SequenceGenerator{}, nil
+	case IDULID:
+		return &ULIDGenerator{}, nil
+	case IDUUIDv7:
+		return &UUIDv7Generator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown ID strategy %q", strategy)
+	}
+}
+
+// SequenceGenerator issues 1, 2, 3, ... from a persisted counter
+type SequenceGenerator struct{}
+
+func (SequenceGenerator) Next(seq *uint64) (models.TaskID, error) {
+	*seq++
+	return models.TaskID(fmt.Sprint(*seq)), nil
+}
+
+func (SequenceGenerator) Parse(s string) (models.TaskID, error) {
+	id := models.TaskID(s)
+	if !id.IsNumeric() || id.Number() == 0 {
+		return "", ErrInvalidID
+	}
+	return id, nil
+}
+
+// parseLegacy accepts sequence IDs, so tasks created before switching to
+// a string strategy stay reachable
+func parseLegacy(s string) (models.TaskID, bool) {
+	id, err := SequenceGenerator{}.Parse(s)
+	return id, err == nil
+}
+
+// crockford is the ULID base32 alphabet
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDGenerator issues 26-character ULIDs: a millisecond timestamp
+// followed by randomness, so they sort by creation time. IDs made in the
+// same millisecond increment the random part to stay ordered.
+type ULIDGenerator struct {
+	mu      sync.Mutex
+	lastMs  uint64
+	lastRnd [10]byte
+}
+
+func (g *ULIDGenerator) Next(seq *uint64) (models.TaskID, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(time.Now().UnixMilli())
+	if ms <= g.lastMs {
+		ms = g.lastMs
+		if !increment(g.lastRnd[:]) {
+			return "", errors.New("ULID random part overflowed within one millisecond")
+		}
+	} else {
+		if _, err := rand.Read(g.lastRnd[:]); err != nil {
+			return "", err
+		}
+		g.lastMs = ms
+	}
+
+	var raw [16]byte
+	raw[0] = byte(ms >> 40)
+	raw[1] = byte(ms >> 32)
+	binary.BigEndian.PutUint32(raw[2:6], uint32(ms))
+	copy(raw[6:], g.lastRnd[:])
+
+	return models.TaskID(encodeCrockford(raw)), nil
+}
+
+func (g *ULIDGenerator) Parse(s string) (models.TaskID, error) {
+	if id, ok := parseLegacy(s); ok {
+		return id, nil
+	}
+
+	s = strings.ToUpper(s)
+	if len(s) != 26 || s[0] > '7' {
+		return "", ErrInvalidID
+	}
+	for _, c := range s {
+		if !strings.ContainsRune(crockford, c) {
+			return "", ErrInvalidID
+		}
+	}
+	return models.TaskID(s), nil
+}
+
+// encodeCrockford renders 128 bits as 26 base32 characters
+func encodeCrockford(raw [16]byte) string {
+	hi := binary.BigEndian.Uint64(raw[:8])
+	lo := binary.BigEndian.Uint64(raw[8:])
+
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// increment adds one to a big-endian number; false means it wrapped
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// UUIDv7Generator issues RFC 9562 version 7 UUIDs: a millisecond
+// timestamp followed by randomness, so they sort by creation time
+type UUIDv7Generator struct{}
+
+func (g *UUIDv7Generator) Next(seq *uint64) (models.TaskID, error) {
+	var raw [16]byte
+	if _, err := rand.Read(raw[6:]); err != nil {
+		return "", err
+	}
+
+	ms := uint64(time.Now().UnixMilli())
+	raw[0] = byte(ms >> 40)
+	raw[1] = byte(ms >> 32)
+	binary.BigEndian.PutUint32(raw[2:6], uint32(ms))
+	raw[6] = raw[6]&0x0f | 0x70 // version 7
+	raw[8] = raw[8]&0x3f | 0x80 // RFC variant
+
+	h := hex.EncodeToString(raw[:])
+	return models.TaskID(h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]), nil
+}
+
+func (g *UUIDv7Generator) Parse(s string) (models.TaskID, error) {
+	if id, ok := parseLegacy(s); ok {
+		return id, nil
+	}
+
+	s = strings.ToLower(s)
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' || s[14] != '7' {
+		return "", ErrInvalidID
+	}
+	if _, err := hex.DecodeString(strings.ReplaceAll(s, "-", "")); err != nil {
+		return "", ErrInvalidID
+	}
+	return models.TaskID(s), nil
+}
+
+// seqAtLeast raises seq past a numeric ID found in stored data, so a
+// sequence that was lost or hand-edited still never reissues an ID
+func seqAtLeast(seq *uint64, id models.TaskID) {
+	if n := id.Number(); n > *seq {
+		*seq = n
+	}
+}
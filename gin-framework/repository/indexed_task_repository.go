@@ -0,0 +1,350 @@
+package repository
+
+import (
+	"context"
+	"gin-framework/database"
+	"gin-framework/models"
+	"sync"
+	"time"
+)
+
+// IndexedTaskRepository serves reads from an in-memory index and writes
+// every change through to the JSONDatabase before acknowledging it, so
+// lookups are O(1) and listing never touches the disk.
+//
+// Writes run inside db.Update and only touch the index from OnCommit, once
+// the file is saved. The database calls Reload with its own lock held, so
+// r.mu is always taken after the database lock and the two cannot
+// deadlock.
+type IndexedTaskRepository struct {
+	tasks *database.Collection
+	ids   IDGenerator
+
+	mu    sync.RWMutex
+	index *taskIndex
+	seq   uint64
+}
+
+// NewIndexedTaskRepository loads all tasks from db into memory
+func NewIndexedTaskRepository(db *database.JSONDatabase, ids IDGenerator) (*IndexedTaskRepository, error) {
+	r := &IndexedTaskRepository{
+		tasks: db.Collection(tasksCollection),
+		ids:   ids,
+		index: newTaskIndex(),
+	}
+
+	if err := db.View(r.Reload); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload replaces the index with the file content. It is the
+// database.ReloadFunc used when db.json is changed outside the process.
+func (r *IndexedTaskRepository) Reload(tx *database.Tx) error {
+	var tasks []models.Task
+	if err := tx.Collection(tasksCollection).Read(&tasks); err != nil {
+		return err
+	}
+	seq, err := readSequence(tx, tasks)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.index.load(tasks)
+	r.seq = seq
+	return nil
+}
+
+// persist writes the index with task replaced (or removed when deleted is
+// true) into tx. Callers must hold r.mu.
+func (r *IndexedTaskRepository) persist(tx *database.Tx, task models.Task, deleted bool) error {
+	tasks := make([]models.Task, 0, r.index.len()+1)
+	replaced := false
+	for _, t := range r.index.all() {
+		if t.ID == task.ID {
+			replaced = true
+			if deleted {
+				continue
+			}
+			t = task
+		}
+		tasks = append(tasks, t)
+	}
+	if !replaced && !deleted {
+		tasks = append(tasks, task)
+	}
+
+	return tx.Write(tasks)
+}
+
+func (r *IndexedTaskRepository) Get(ctx context.Context, id models.TaskID) (*models.Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	task, ok := r.index.get(id)
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	return &task, nil
+}
+
+func (r *IndexedTaskRepository) List(ctx context.Context) ([]models.Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.index.all(), nil
+}
+
+// ListByCompleted returns the tasks with the given status using the
+// Completed index
+func (r *IndexedTaskRepository) ListByCompleted(ctx context.Context, completed bool) ([]models.Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.index.byCompleted(completed), nil
+}
+
+// ListCreatedBetween returns the tasks created in [from, to) oldest first
+// using the CreatedAt index. A zero bound is open.
+func (r *IndexedTaskRepository) ListCreatedBetween(ctx context.Context, from, to time.Time) ([]models.Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.index.createdBetween(from, to), nil
+}
+
+func (r *IndexedTaskRepository) Scan(ctx context.Context, fn func(task models.Task) bool) error {
+	tasks, err := r.List(ctx)
+	if err != nil {
+		return err
+	}
+	return scanTasks(ctx, tasks, fn)
+}
+
+// ReplaceAll replaces every task with tasks, stored as given
+func (r *IndexedTaskRepository) ReplaceAll(ctx context.Context, tasks []models.Task) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return r.tasks.Update(func(tx *database.Tx) error {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		seq := r.seq
+		for _, task := range tasks {
+			seqAtLeast(&seq, task.ID)
+		}
+		if err := tx.Write(tasks); err != nil {
+			return err
+		}
+		if err := writeSequence(tx, seq); err != nil {
+			return err
+		}
+
+		tx.OnCommit(func() {
+			r.mu.Lock()
+			r.index.load(tasks)
+			r.seq = seq
+			r.mu.Unlock()
+		})
+		return nil
+	})
+}
+
+// Apply stores a replicated change as given
+func (r *IndexedTaskRepository) Apply(ctx context.Context, change models.Change) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkChange(change); err != nil {
+		return err
+	}
+
+	return r.tasks.Update(func(tx *database.Tx) error {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		task, deleted := models.Task{ID: change.TaskID}, change.Op == models.ChangeDelete
+		if !deleted {
+			task = *change.Task
+		}
+		if err := r.persist(tx, task, deleted); err != nil {
+			return err
+		}
+		seq := r.seq
+		seqAtLeast(&seq, task.ID)
+		if err := writeSequence(tx, seq); err != nil {
+			return err
+		}
+
+		tx.OnCommit(func() {
+			r.mu.Lock()
+			applyChange(r.index, &r.seq, change)
+			r.mu.Unlock()
+		})
+		return nil
+	})
+}
+
+func (r *IndexedTaskRepository) Create(ctx context.Context, task *models.Task) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return r.tasks.Update(func(tx *database.Tx) error {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		seq := r.seq
+		id, err := r.ids.Next(&seq)
+		if err != nil {
+			return err
+		}
+		task.ID = id
+		task.Version = 1
+
+		if err := r.persist(tx, *task, false); err != nil {
+			return err
+		}
+		if err := writeSequence(tx, seq); err != nil {
+			return err
+		}
+
+		created := *task
+		tx.OnCommit(func() {
+			r.mu.Lock()
+			r.index.put(created)
+			r.seq = seq
+			r.mu.Unlock()
+		})
+		return nil
+	})
+}
+
+func (r *IndexedTaskRepository) Update(ctx context.Context, id models.TaskID, fn func(task *models.Task) error) (*models.Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var updated models.Task
+	err := r.tasks.Update(func(tx *database.Tx) error {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		task, ok := r.index.get(id)
+		if !ok {
+			return ErrTaskNotFound
+		}
+		version := task.Version
+		if err := fn(&task); err != nil {
+			return err
+		}
+		task.ID = id
+		task.Version = version + 1
+
+		if err := r.persist(tx, task, false); err != nil {
+			return err
+		}
+		updated = task
+
+		tx.OnCommit(func() {
+			r.mu.Lock()
+			r.index.put(task)
+			r.mu.Unlock()
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+func (r *IndexedTaskRepository) Delete(ctx context.Context, id models.TaskID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return r.tasks.Update(func(tx *database.Tx) error {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		task, ok := r.index.get(id)
+		if !ok {
+			return ErrTaskNotFound
+		}
+		if err := r.persist(tx, task, true); err != nil {
+			return err
+		}
+
+		tx.OnCommit(func() {
+			r.mu.Lock()
+			r.index.remove(id)
+			r.mu.Unlock()
+		})
+		return nil
+	})
+}
+
+func (r *IndexedTaskRepository) DeleteMatching(ctx context.Context, match func(task models.Task) bool) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var removed []models.TaskID
+	err := r.tasks.Update(func(tx *database.Tx) error {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		kept := make([]models.Task, 0, r.index.len())
+		for _, task := range r.index.all() {
+			if match(task) {
+				removed = append(removed, task.ID)
+				continue
+			}
+			kept = append(kept, task)
+		}
+		if len(removed) == 0 {
+			return nil
+		}
+		if err := tx.Write(kept); err != nil {
+			return err
+		}
+
+		tx.OnCommit(func() {
+			r.mu.Lock()
+			for _, id := range removed {
+				r.index.remove(id)
+			}
+			r.mu.Unlock()
+		})
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(removed), nil
+}
@@ -0,0 +1,328 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"gin-framework/database"
+	"gin-framework/models"
+	"sort"
+)
+
+// JSONTaskRepository stores tasks as an array in the "tasks" collection,
+// kept in ID order, and reads the file on every call
+type JSONTaskRepository struct {
+	tasks *database.Collection
+	ids   IDGenerator
+}
+
+func NewJSONTaskRepository(db *database.JSONDatabase, ids IDGenerator) *JSONTaskRepository {
+	return &JSONTaskRepository{tasks: db.Collection(tasksCollection), ids: ids}
+}
+
+// Reload has no cached state to drop, since every call reads the file.
+// Watching the file with it still tells the wrappers that cache tasks,
+// like the search index and the change log, about external changes.
+func (r *JSONTaskRepository) Reload(tx *database.Tx) error {
+	return nil
+}
+
+func (r *JSONTaskRepository) Get(ctx context.Context, id models.TaskID) (*models.Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var found *models.Task
+	err := scanPinned(ctx, r.tasks, func(task models.Task) bool {
+		if task.ID == id {
+			found = &task
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrTaskNotFound
+	}
+
+	return found, nil
+}
+
+func (r *JSONTaskRepository) List(ctx context.Context) ([]models.Task, error) {
+	tasks := []models.Task{}
+	err := r.Scan(ctx, func(task models.Task) bool {
+		tasks = append(tasks, task)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+// Scan decodes the tasks one at a time while streaming the file. A file
+// someone else wrote out of ID order is sorted in memory instead, until
+// the next write here stores it sorted.
+func (r *JSONTaskRepository) Scan(ctx context.Context, fn func(task models.Task) bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	pinned, err := r.tasks.Pin()
+	if err != nil {
+		return err
+	}
+	defer pinned.Close()
+
+	sorted, err := inIDOrder(ctx, pinned)
+	if err != nil {
+		return err
+	}
+	if sorted {
+		return scanPinned(ctx, pinned, fn)
+	}
+
+	var tasks []models.Task
+	err = scanPinned(ctx, pinned, func(task models.Task) bool {
+		tasks = append(tasks, task)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	sortByID(tasks)
+	return scanTasks(ctx, tasks, fn)
+}
+
+// rawScanner is a collection, or a pinned view of one, that can be scanned
+type rawScanner interface {
+	Scan(fn func(raw json.RawMessage) bool) error
+}
+
+// scanPinned decodes the tasks of src one at a time in stored order
+func scanPinned(ctx context.Context, src rawScanner, fn func(task models.Task) bool) error {
+	var scanErr error
+	err := src.Scan(func(raw json.RawMessage) bool {
+		if scanErr = ctx.Err(); scanErr != nil {
+			return false
+		}
+		var task models.Task
+		if scanErr = json.Unmarshal(raw, &task); scanErr != nil {
+			return false
+		}
+		return fn(task)
+	})
+	if err != nil {
+		return err
+	}
+	return scanErr
+}
+
+// inIDOrder reports whether the tasks of src are stored in ID order,
+// decoding only their IDs
+func inIDOrder(ctx context.Context, src rawScanner) (bool, error) {
+	sorted := true
+	var prev *models.TaskID
+	var scanErr error
+	err := src.Scan(func(raw json.RawMessage) bool {
+		if scanErr = ctx.Err(); scanErr != nil {
+			return false
+		}
+		var head struct {
+			ID models.TaskID `json:"id"`
+		}
+		if scanErr = json.Unmarshal(raw, &head); scanErr != nil {
+			return false
+		}
+		if prev != nil && !prev.Less(head.ID) {
+			sorted = false
+			return false
+		}
+		prev = &head.ID
+		return true
+	})
+	if err != nil {
+		return false, err
+	}
+	return sorted, scanErr
+}
+
+func sortByID(tasks []models.Task) {
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID.Less(tasks[j].ID) })
+}
+
+// writeTasks stores tasks in ID order
+func writeTasks(tx *database.Tx, tasks []models.Task) error {
+	sortByID(tasks)
+	return tx.Write(tasks)
+}
+
+// ReplaceAll replaces every task with tasks, stored as given
+func (r *JSONTaskRepository) ReplaceAll(ctx context.Context, tasks []models.Task) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Sorted for the file without reordering the caller's slice
+	tasks = append([]models.Task(nil), tasks...)
+	return r.tasks.Update(func(tx *database.Tx) error {
+		seq, err := readSequence(tx, tasks)
+		if err != nil {
+			return err
+		}
+		if err := writeTasks(tx, tasks); err != nil {
+			return err
+		}
+		return writeSequence(tx, seq)
+	})
+}
+
+// Apply stores a replicated change as given
+func (r *JSONTaskRepository) Apply(ctx context.Context, change models.Change) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkChange(change); err != nil {
+		return err
+	}
+
+	return r.tasks.Update(func(tx *database.Tx) error {
+		var tasks []models.Task
+		if err := tx.Read(&tasks); err != nil {
+			return err
+		}
+
+		tasks = applyToSlice(tasks, change)
+		seq, err := readSequence(tx, tasks)
+		if err != nil {
+			return err
+		}
+		if err := writeTasks(tx, tasks); err != nil {
+			return err
+		}
+		return writeSequence(tx, seq)
+	})
+}
+
+func (r *JSONTaskRepository) Create(ctx context.Context, task *models.Task) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Read, pick the ID and write under one lock so concurrent
+	// creates never get the same ID or overwrite each other
+	return r.tasks.Update(func(tx *database.Tx) error {
+		var tasks []models.Task
+		if err := tx.Read(&tasks); err != nil {
+			return err
+		}
+
+		seq, err := readSequence(tx, tasks)
+		if err != nil {
+			return err
+		}
+		if task.ID, err = r.ids.Next(&seq); err != nil {
+			return err
+		}
+		task.Version = 1
+
+		if err := writeTasks(tx, append(tasks, *task)); err != nil {
+			return err
+		}
+		return writeSequence(tx, seq)
+	})
+}
+
+func (r *JSONTaskRepository) Update(ctx context.Context, id models.TaskID, fn func(task *models.Task) error) (*models.Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var updated models.Task
+	err := r.tasks.Update(func(tx *database.Tx) error {
+		var tasks []models.Task
+		if err := tx.Read(&tasks); err != nil {
+			return err
+		}
+
+		for i := range tasks {
+			if tasks[i].ID != id {
+				continue
+			}
+
+			version := tasks[i].Version
+			if err := fn(&tasks[i]); err != nil {
+				return err
+			}
+			// The ID is the key; fn must not move the task
+			tasks[i].ID = id
+			tasks[i].Version = version + 1
+			updated = tasks[i]
+
+			return writeTasks(tx, tasks)
+		}
+
+		return ErrTaskNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+func (r *JSONTaskRepository) Delete(ctx context.Context, id models.TaskID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return r.tasks.Update(func(tx *database.Tx) error {
+		var tasks []models.Task
+		if err := tx.Read(&tasks); err != nil {
+			return err
+		}
+
+		for i, task := range tasks {
+			if task.ID == id {
+				// Remove task from slice
+				return writeTasks(tx, append(tasks[:i], tasks[i+1:]...))
+			}
+		}
+
+		return ErrTaskNotFound
+	})
+}
+
+func (r *JSONTaskRepository) DeleteMatching(ctx context.Context, match func(task models.Task) bool) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	err := r.tasks.Update(func(tx *database.Tx) error {
+		var tasks []models.Task
+		if err := tx.Read(&tasks); err != nil {
+			return err
+		}
+
+		kept := tasks[:0]
+		for _, task := range tasks {
+			if match(task) {
+				removed++
+				continue
+			}
+			kept = append(kept, task)
+		}
+		if removed == 0 {
+			return nil
+		}
+		return writeTasks(tx, kept)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"context"
+	"gin-framework/models"
+	"sync"
+)
+
+// MemoryTaskRepository keeps tasks in an in-memory index. Nothing is
+// persisted, which makes it handy for tests and throwaway environments.
+type MemoryTaskRepository struct {
+	ids IDGenerator
+
+	mu    sync.RWMutex
+	index *taskIndex
+	seq   uint64
+}
+
+func NewMemoryTaskRepository(ids IDGenerator) *MemoryTaskRepository {
+	return &MemoryTaskRepository{ids: ids, index: newTaskIndex()}
+}
+
+func (r *MemoryTaskRepository) Get(ctx context.Context, id models.TaskID) (*models.Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	task, ok := r.index.get(id)
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	return &task, nil
+}
+
+func (r *MemoryTaskRepository) List(ctx context.Context) ([]models.Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.index.all(), nil
+}
+
+func (r *MemoryTaskRepository) Scan(ctx context.Context, fn func(task models.Task) bool) error {
+	tasks, err := r.List(ctx)
+	if err != nil {
+		return err
+	}
+	return scanTasks(ctx, tasks, fn)
+}
+
+// ReplaceAll replaces every task with tasks, stored as given
+func (r *MemoryTaskRepository) ReplaceAll(ctx context.Context, tasks []models.Task) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.index.load(tasks)
+	for _, task := range tasks {
+		seqAtLeast(&r.seq, task.ID)
+	}
+	return nil
+}
+
+// Apply stores a replicated change as given
+func (r *MemoryTaskRepository) Apply(ctx context.Context, change models.Change) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkChange(change); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	applyChange(r.index, &r.seq, change)
+	return nil
+}
+
+func (r *MemoryTaskRepository) Create(ctx context.Context, task *models.Task) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := r.ids.Next(&r.seq)
+	if err != nil {
+		return err
+	}
+	task.ID = id
+	task.Version = 1
+	r.index.put(*task)
+
+	return nil
+}
+
+func (r *MemoryTaskRepository) Update(ctx context.Context, id models.TaskID, fn func(task *models.Task) error) (*models.Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	task, ok := r.index.get(id)
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	version := task.Version
+	if err := fn(&task); err != nil {
+		return nil, err
+	}
+	task.ID = id
+	task.Version = version + 1
+	r.index.put(task)
+
+	return &task, nil
+}
+
+func (r *MemoryTaskRepository) Delete(ctx context.Context, id models.TaskID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.index.remove(id) {
+		return ErrTaskNotFound
+	}
+
+	return nil
+}
+
+func (r *MemoryTaskRepository) DeleteMatching(ctx context.Context, match func(task models.Task) bool) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	removed := 0
+	for _, task := range r.index.all() {
+		if match(task) {
+			r.index.remove(task.ID)
+			removed++
+		}
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"gin-framework/models"
+	"sync"
+)
+
+// writeHooks are told about every write that went through an
+// observedRepository, in commit order
+type writeHooks struct {
+	put     func(task models.Task)    // a task was created or changed
+	remove  func(id models.TaskID)    // a task was deleted
+	replace func(tasks []models.Task) // a follower replaced every task
+}
+
+// observedRepository wraps a backend and calls hooks after each write
+// that goes through it. It is the common part of the wrappers that keep
+// state derived from the tasks, like SearchIndex, TitleSuggester and
+// ChangeLog.
+type observedRepository struct {
+	repo  TaskRepository
+	hooks writeHooks
+
+	writeMu sync.Mutex // held across each write and its hook, so the hooks follow commit order
+}
+
+func newObservedRepository(repo TaskRepository, hooks writeHooks) *observedRepository {
+	return &observedRepository{repo: repo, hooks: hooks}
+}
+
+// exclusive runs fn while no write goes through, e.g. to rebuild the
+// derived state from the backend
+func (o *observedRepository) exclusive(fn func() error) error {
+	o.writeMu.Lock()
+	defer o.writeMu.Unlock()
+	return fn()
+}
+
+func (o *observedRepository) Get(ctx context.Context, id models.TaskID) (*models.Task, error) {
+	return o.repo.Get(ctx, id)
+}
+
+func (o *observedRepository) List(ctx context.Context) ([]models.Task, error) {
+	return o.repo.List(ctx)
+}
+
+func (o *observedRepository) Scan(ctx context.Context, fn func(task models.Task) bool) error {
+	return o.repo.Scan(ctx, fn)
+}
+
+func (o *observedRepository) Create(ctx context.Context, task *models.Task) error {
+	o.writeMu.Lock()
+	defer o.writeMu.Unlock()
+
+	if err := o.repo.Create(ctx, task); err != nil {
+		return err
+	}
+	o.hooks.put(*task)
+	return nil
+}
+
+func (o *observedRepository) Update(ctx context.Context, id models.TaskID, fn func(task *models.Task) error) (*models.Task, error) {
+	o.writeMu.Lock()
+	defer o.writeMu.Unlock()
+
+	updated, err := o.repo.Update(ctx, id, fn)
+	if err != nil {
+		return nil, err
+	}
+	o.hooks.put(*updated)
+	return updated, nil
+}
+
+func (o *observedRepository) Delete(ctx context.Context, id models.TaskID) error {
+	o.writeMu.Lock()
+	defer o.writeMu.Unlock()
+
+	if err := o.repo.Delete(ctx, id); err != nil {
+		return err
+	}
+	o.hooks.remove(id)
+	return nil
+}
+
+func (o *observedRepository) DeleteMatching(ctx context.Context, match func(task models.Task) bool) (int, error) {
+	o.writeMu.Lock()
+	defer o.writeMu.Unlock()
+
+	var removed []models.TaskID
+	n, err := o.repo.DeleteMatching(ctx, func(task models.Task) bool {
+		if !match(task) {
+			return false
+		}
+		removed = append(removed, task.ID)
+		return true
+	})
+	if err != nil {
+		return n, err
+	}
+	for _, id := range removed {
+		o.hooks.remove(id)
+	}
+	return n, nil
+}
+
+// ReplaceAll passes a follower's snapshot to the backend
+func (o *observedRepository) ReplaceAll(ctx context.Context, tasks []models.Task) error {
+	replica, err := asReplica(o.repo)
+	if err != nil {
+		return err
+	}
+
+	o.writeMu.Lock()
+	defer o.writeMu.Unlock()
+
+	if err := replica.ReplaceAll(ctx, tasks); err != nil {
+		return err
+	}
+	o.hooks.replace(tasks)
+	return nil
+}
+
+// Apply passes a replicated change to the backend
+func (o *observedRepository) Apply(ctx context.Context, change models.Change) error {
+	replica, err := asReplica(o.repo)
+	if err != nil {
+		return err
+	}
+
+	o.writeMu.Lock()
+	defer o.writeMu.Unlock()
+
+	if err := replica.Apply(ctx, change); err != nil {
+		return err
+	}
+	if change.Op == models.ChangeDelete {
+		o.hooks.remove(change.TaskID)
+	} else {
+		o.hooks.put(*change.Task)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"container/heap"
+	"context"
+	"gin-framework/models"
+	"sort"
+)
+
+// PageRequest selects one page of the tasks Match accepts in Order,
+// either by position (Offset) or relative to the task a previous page
+// ended at (After or Before). Paging relative to a task is stable: tasks
+// created or deleted meanwhile do not shift the pages still to come.
+type PageRequest struct {
+	Limit  int
+	Offset int
+	Match  func(task models.Task) bool // nil accepts every task
+	Order  SortOrder                   // ID order when empty
+	After  *models.Task                // tasks ordered after this one
+	Before *models.Task                // the last Limit tasks ordered before this one
+}
+
+// Page is one page of tasks and where it sits in the list of every
+// matching task
+type Page struct {
+	Tasks   []models.Task
+	Total   int
+	HasNext bool
+	HasPrev bool
+}
+
+// Paginate cuts the page req asks for from the tasks scan yields. Only the
+// tasks that can still end up on the page are held, so memory grows with
+// the page (plus the offset), not with the store.
+func Paginate(ctx context.Context, scan func(ctx context.Context, fn func(task models.Task) bool) error, req PageRequest) (*Page, error) {
+	less := req.Order.Less
+
+	backward := req.Before != nil
+	var top *topTasks
+	if backward {
+		top = newTopTasks(req.Limit, func(a, b models.Task) bool { return less(b, a) })
+	} else {
+		top = newTopTasks(req.Offset+req.Limit, less)
+	}
+
+	total, candidates := 0, 0
+	err := scan(ctx, func(task models.Task) bool {
+		if req.Match != nil && !req.Match(task) {
+			return true
+		}
+		total++
+		if req.After != nil && !less(*req.After, task) {
+			return true
+		}
+		if backward && !less(task, *req.Before) {
+			return true
+		}
+		candidates++
+		top.add(task)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Tasks: top.sorted(), Total: total}
+	switch {
+	case backward:
+		// Kept in reverse order
+		for i, j := 0, len(page.Tasks)-1; i < j; i, j = i+1, j-1 {
+			page.Tasks[i], page.Tasks[j] = page.Tasks[j], page.Tasks[i]
+		}
+		page.HasPrev = candidates > req.Limit
+		page.HasNext = candidates < total
+	case req.After != nil:
+		page.HasNext = candidates > req.Limit
+		page.HasPrev = candidates < total
+	default:
+		if req.Offset < len(page.Tasks) {
+			page.Tasks = page.Tasks[req.Offset:]
+		} else {
+			page.Tasks = []models.Task{}
+		}
+		page.HasNext = req.Offset+req.Limit < total
+		page.HasPrev = req.Offset > 0 && total > 0
+	}
+	return page, nil
+}
+
+// topTasks keeps the n tasks that come first by less. It is a heap with
+// the last of the kept tasks on top, so it can be evicted cheaply.
+type topTasks struct {
+	n     int
+	less  func(a, b models.Task) bool
+	tasks []models.Task
+}
+
+func newTopTasks(n int, less func(a, b models.Task) bool) *topTasks {
+	return &topTasks{n: n, less: less, tasks: []models.Task{}}
+}
+
+func (t *topTasks) add(task models.Task) {
+	switch {
+	case t.n <= 0:
+	case len(t.tasks) < t.n:
+		heap.Push(t, task)
+	case t.less(task, t.tasks[0]):
+		t.tasks[0] = task
+		heap.Fix(t, 0)
+	}
+}
+
+// sorted returns the kept tasks in less order
+func (t *topTasks) sorted() []models.Task {
+	sort.Slice(t.tasks, func(i, j int) bool {
+		return t.less(t.tasks[i], t.tasks[j])
+	})
+	return t.tasks
+}
+
+func (t *topTasks) Len() int           { return len(t.tasks) }
+func (t *topTasks) Less(i, j int) bool { return t.less(t.tasks[j], t.tasks[i]) }
+func (t *topTasks) Swap(i, j int)      { t.tasks[i], t.tasks[j] = t.tasks[j], t.tasks[i] }
+
+func (t *topTasks) Push(x interface{}) {
+	t.tasks = append(t.tasks, x.(models.Task))
+}
+
+func (t *topTasks) Pop() interface{} {
+	last := t.tasks[len(t.tasks)-1]
+	t.tasks = t.tasks[:len(t.tasks)-1]
+	return last
+}
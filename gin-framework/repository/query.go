@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"gin-framework/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TaskFilter selects tasks by their fields; zero fields match every task
+type TaskFilter struct {
+	Completed     *bool
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+	// Case-insensitive substrings
+	TitleContains       string
+	DescriptionContains string
+}
+
+// Match reports whether task passes every set condition
+func (f TaskFilter) Match(task models.Task) bool {
+	switch {
+	case f.Completed != nil && task.Completed != *f.Completed:
+		return false
+	case !f.CreatedAfter.IsZero() && !task.CreatedAt.After(f.CreatedAfter):
+		return false
+	case !f.CreatedBefore.IsZero() && !task.CreatedAt.Before(f.CreatedBefore):
+		return false
+	case !f.UpdatedAfter.IsZero() && !task.UpdatedAt.After(f.UpdatedAfter):
+		return false
+	case !f.UpdatedBefore.IsZero() && !task.UpdatedAt.Before(f.UpdatedBefore):
+		return false
+	case f.TitleContains != "" && !containsFold(task.Title, f.TitleContains):
+		return false
+	case f.DescriptionContains != "" && !containsFold(task.Description, f.DescriptionContains):
+		return false
+	}
+	return true
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// sortField compares tasks by one field and copies it between tasks
+type sortField struct {
+	compare func(a, b models.Task) int
+	copy    func(dst *models.Task, src models.Task)
+}
+
+var sortFields = map[string]sortField{
+	"id": {
+		compare: func(a, b models.Task) int { return compareIDs(a.ID, b.ID) },
+		copy:    func(dst *models.Task, src models.Task) { dst.ID = src.ID },
+	},
+	"title": {
+		compare: func(a, b models.Task) int { return compareFold(a.Title, b.Title) },
+		copy:    func(dst *models.Task, src models.Task) { dst.Title = src.Title },
+	},
+	"description": {
+		compare: func(a, b models.Task) int { return compareFold(a.Description, b.Description) },
+		copy:    func(dst *models.Task, src models.Task) { dst.Description = src.Description },
+	},
+	"completed": {
+		compare: func(a, b models.Task) int { return compareBools(a.Completed, b.Completed) },
+		copy:    func(dst *models.Task, src models.Task) { dst.Completed = src.Completed },
+	},
+	"version": {
+		compare: func(a, b models.Task) int { return cmp.Compare(a.Version, b.Version) },
+		copy:    func(dst *models.Task, src models.Task) { dst.Version = src.Version },
+	},
+	"created_at": {
+		compare: func(a, b models.Task) int { return a.CreatedAt.Compare(b.CreatedAt) },
+		copy:    func(dst *models.Task, src models.Task) { dst.CreatedAt = src.CreatedAt },
+	},
+	"updated_at": {
+		compare: func(a, b models.Task) int { return a.UpdatedAt.Compare(b.UpdatedAt) },
+		copy:    func(dst *models.Task, src models.Task) { dst.UpdatedAt = src.UpdatedAt },
+	},
+}
+
+// SortFields lists the fields tasks can be sorted by
+func SortFields() []string {
+	names := make([]string, 0, len(sortFields))
+	for name := range sortFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func compareIDs(a, b models.TaskID) int {
+	switch {
+	case a.Less(b):
+		return -1
+	case b.Less(a):
+		return 1
+	}
+	return 0
+}
+
+// compareFold orders strings ignoring case, then byte-wise so the order
+// is total
+func compareFold(a, b string) int {
+	if c := strings.Compare(strings.ToLower(a), strings.ToLower(b)); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+func compareBools(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case b:
+		return -1
+	}
+	return 1
+}
+
+// SortKey orders tasks by one field
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// SortOrder orders tasks by its keys in turn and finally by ID, so every
+// order is total and pages never overlap
+type SortOrder []SortKey
+
+// ParseSort reads a comma-separated list of fields, each prefixed with
+// "-" for descending order, e.g. "-updated_at,title"
+func ParseSort(s string) (SortOrder, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var order SortOrder
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ",") {
+		key := SortKey{Field: strings.TrimSpace(part)}
+		if strings.HasPrefix(key.Field, "-") {
+			key.Field, key.Desc = key.Field[1:], true
+		}
+		if key.Field == "" {
+			return nil, errors.New("empty sort field")
+		}
+		if _, ok := sortFields[key.Field]; !ok {
+			return nil, fmt.Errorf("unknown sort field %q, use one of %s", key.Field, strings.Join(SortFields(), ", "))
+		}
+		if seen[key.Field] {
+			return nil, fmt.Errorf("sort field %q is listed twice", key.Field)
+		}
+		seen[key.Field] = true
+		order = append(order, key)
+	}
+	return order, nil
+}
+
+// String formats the order the way ParseSort reads it
+func (o SortOrder) String() string {
+	parts := make([]string, len(o))
+	for i, key := range o {
+		parts[i] = key.Field
+		if key.Desc {
+			parts[i] = "-" + key.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Less reports whether a comes before b
+func (o SortOrder) Less(a, b models.Task) bool {
+	for _, key := range o {
+		c := sortFields[key.Field].compare(a, b)
+		if key.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+	}
+	return a.ID.Less(b.ID)
+}
+
+// Has reports whether o sorts by field
+func (o SortOrder) Has(field string) bool {
+	for _, key := range o {
+		if key.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Key returns a task holding only the fields of task that o compares,
+// which is all a page boundary needs to remember
+func (o SortOrder) Key(task models.Task) models.Task {
+	key := models.Task{ID: task.ID}
+	for _, k := range o {
+		sortFields[k.Field].copy(&key, task)
+	}
+	return key
+}
@@ -0,0 +1,217 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"gin-framework/database"
+	"gin-framework/models"
+	"log"
+	"sort"
+	"sync"
+)
+
+// revisionsCollection is where revisions were kept in the database file
+// before they moved to their own journal; it maps task IDs to their
+// revisions, oldest first
+const revisionsCollection = "revisions"
+
+// RevisionStore keeps the revision history of every task
+type RevisionStore interface {
+	// Append numbers rev as the next revision of its task and stores it
+	Append(ctx context.Context, rev *models.Revision) error
+	// List returns the revisions of a task oldest first
+	List(ctx context.Context, id models.TaskID) ([]models.Revision, error)
+	// Tasks returns the IDs of every task with revisions, in ID order
+	Tasks(ctx context.Context) ([]models.TaskID, error)
+}
+
+// JournalRevisionStore appends each revision as one line to a journal next
+// to the database file, so recording a change costs the same however long
+// the history is. The revisions are indexed by task in memory, and lines
+// other processes sharing the database appended are picked up before
+// every call.
+type JournalRevisionStore struct {
+	journal *database.Journal
+
+	mu        sync.Mutex
+	revisions map[models.TaskID][]models.Revision
+}
+
+// NewJournalRevisionStore opens the journal at path and loads it. Revisions
+// still in the "revisions" collection of db are moved to the journal.
+func NewJournalRevisionStore(db *database.JSONDatabase, path string) (*JournalRevisionStore, error) {
+	journal, err := db.OpenJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &JournalRevisionStore{journal: journal, revisions: make(map[models.TaskID][]models.Revision)}
+
+	err = db.Update(func(tx *database.Tx) error {
+		legacy := tx.Collection(revisionsCollection)
+		all := map[models.TaskID][]models.Revision{}
+		if err := legacy.Read(&all); err != nil {
+			return err
+		}
+		if err := s.importRevisions(all); err != nil {
+			return err
+		}
+		return legacy.Drop()
+	})
+	if err != nil {
+		journal.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// importRevisions appends the revisions the journal does not have yet, so
+// an import cut short is simply completed by the next one
+func (s *JournalRevisionStore) importRevisions(all map[models.TaskID][]models.Revision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := revisedTasks(all)
+
+	var added []models.Revision
+	err := s.journal.Update(s.seen, func() ([]interface{}, error) {
+		var values []interface{}
+		for _, id := range ids {
+			if len(s.revisions[id]) >= len(all[id]) {
+				continue
+			}
+			for _, rev := range all[id][len(s.revisions[id]):] {
+				added = append(added, rev)
+				values = append(values, rev)
+			}
+		}
+		return values, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rev := range added {
+		s.revisions[rev.TaskID] = append(s.revisions[rev.TaskID], rev)
+	}
+	if len(added) > 0 {
+		log.Printf("Moved %d revision(s) from the database file to the revision journal", len(added))
+	}
+	return nil
+}
+
+// seen indexes a journal line; callers must hold s.mu
+func (s *JournalRevisionStore) seen(raw json.RawMessage) error {
+	var rev models.Revision
+	if err := json.Unmarshal(raw, &rev); err != nil {
+		return err
+	}
+	s.revisions[rev.TaskID] = append(s.revisions[rev.TaskID], rev)
+	return nil
+}
+
+func (s *JournalRevisionStore) Append(ctx context.Context, rev *models.Revision) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.journal.Update(s.seen, func() ([]interface{}, error) {
+		rev.Number = len(s.revisions[rev.TaskID]) + 1
+		return []interface{}{rev}, nil
+	})
+	if err != nil {
+		return err
+	}
+	s.revisions[rev.TaskID] = append(s.revisions[rev.TaskID], *rev)
+	return nil
+}
+
+func (s *JournalRevisionStore) List(ctx context.Context, id models.TaskID) ([]models.Revision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.journal.View(s.seen); err != nil {
+		return nil, err
+	}
+	return append([]models.Revision(nil), s.revisions[id]...), nil
+}
+
+func (s *JournalRevisionStore) Tasks(ctx context.Context) ([]models.TaskID, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.journal.View(s.seen); err != nil {
+		return nil, err
+	}
+	return revisedTasks(s.revisions), nil
+}
+
+// revisedTasks returns the keys of revisions in ID order
+func revisedTasks(revisions map[models.TaskID][]models.Revision) []models.TaskID {
+	ids := make([]models.TaskID, 0, len(revisions))
+	for id := range revisions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Less(ids[j]) })
+	return ids
+}
+
+// Close closes the journal
+func (s *JournalRevisionStore) Close() error {
+	return s.journal.Close()
+}
+
+// MemoryRevisionStore keeps revisions in memory next to the memory backend
+type MemoryRevisionStore struct {
+	mu        sync.RWMutex
+	revisions map[models.TaskID][]models.Revision
+}
+
+func NewMemoryRevisionStore() *MemoryRevisionStore {
+	return &MemoryRevisionStore{revisions: make(map[models.TaskID][]models.Revision)}
+}
+
+func (s *MemoryRevisionStore) Append(ctx context.Context, rev *models.Revision) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rev.Number = len(s.revisions[rev.TaskID]) + 1
+	s.revisions[rev.TaskID] = append(s.revisions[rev.TaskID], *rev)
+	return nil
+}
+
+func (s *MemoryRevisionStore) List(ctx context.Context, id models.TaskID) ([]models.Revision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]models.Revision(nil), s.revisions[id]...), nil
+}
+
+func (s *MemoryRevisionStore) Tasks(ctx context.Context) ([]models.TaskID, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return revisedTasks(s.revisions), nil
+}
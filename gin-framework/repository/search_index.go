@@ -0,0 +1,519 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"gin-framework/models"
+	"html"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ErrEmptySearch is returned for a search query without any words
+var ErrEmptySearch = errors.New("search query has no words")
+
+// BM25 parameters; a title word counts titleWeight times as much as a
+// description word
+const (
+	bm25K1      = 1.2
+	bm25B       = 0.75
+	titleWeight = 2
+)
+
+// Snippet size in words around the first match in the description
+const (
+	snippetWords  = 30
+	snippetBefore = 8
+)
+
+// SearchIndex wraps a backend and keeps an inverted index over the title
+// and description of every task that is not in the trash. The index is
+// built once on start and then updated with each write that goes through
+// it, so searching never scans the store. It keeps only the words of each
+// task; the hits on the returned page are read from the backend.
+type SearchIndex struct {
+	*observedRepository
+
+	mu          sync.RWMutex
+	docs        map[models.TaskID]*searchDoc
+	postings    map[string]map[models.TaskID]float64 // term -> weighted frequency per task
+	terms       []string                             // every term in order, for prefix lookups
+	totalLength float64
+}
+
+// searchDoc is what the index keeps of one task: the terms of its title
+// and description in order
+type searchDoc struct {
+	title       []string
+	description []string
+	length      float64 // weighted number of words
+}
+
+// searchToken is one word of a text: its lowercase form and where it
+// starts and ends in the text
+type searchToken struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into words of letters and digits
+func tokenize(text string) []searchToken {
+	var tokens []searchToken
+	start := -1
+	for i, r := range text {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case inWord && start < 0:
+			start = i
+		case !inWord && start >= 0:
+			tokens = append(tokens, searchToken{term: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, searchToken{term: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+	return tokens
+}
+
+// termsOf returns the lowercase words of text in order
+func termsOf(text string) []string {
+	tokens := tokenize(text)
+	words := make([]string, len(tokens))
+	for i, t := range tokens {
+		words[i] = t.term
+	}
+	return words
+}
+
+// NewSearchIndex indexes every task of repo
+func NewSearchIndex(repo TaskRepository) (*SearchIndex, error) {
+	s := &SearchIndex{}
+	s.observedRepository = newObservedRepository(repo, writeHooks{put: s.index, remove: s.unindex, replace: s.replaced})
+	if err := s.Rebuild(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Rebuild indexes the backend from scratch, for when its content changed
+// without going through the index (an external edit or a restore)
+func (s *SearchIndex) Rebuild() error {
+	return s.exclusive(func() error {
+		fresh := &SearchIndex{}
+		fresh.reset()
+		err := s.repo.Scan(context.Background(), func(task models.Task) bool {
+			fresh.put(task)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		s.docs, s.postings, s.terms, s.totalLength = fresh.docs, fresh.postings, fresh.terms, fresh.totalLength
+		s.mu.Unlock()
+		return nil
+	})
+}
+
+// RebuildLater rebuilds in the background; use it from database reload
+// hooks, which run under the database lock the rebuild needs
+func (s *SearchIndex) RebuildLater() {
+	go func() {
+		if err := s.Rebuild(); err != nil {
+			log.Printf("ERROR: rebuilding the search index: %v", err)
+		}
+	}()
+}
+
+// reset empties the index; callers must hold s.mu or own s exclusively
+func (s *SearchIndex) reset() {
+	s.docs = make(map[models.TaskID]*searchDoc)
+	s.postings = make(map[string]map[models.TaskID]float64)
+	s.terms = nil
+	s.totalLength = 0
+}
+
+// put indexes task, replacing its previous content; trashed tasks are
+// left out. Callers must hold s.mu or own s exclusively.
+func (s *SearchIndex) put(task models.Task) {
+	s.remove(task.ID)
+	if task.DeletedAt != nil {
+		return
+	}
+
+	doc := &searchDoc{title: termsOf(task.Title), description: termsOf(task.Description)}
+	frequencies := map[string]float64{}
+	for _, term := range doc.title {
+		frequencies[term] += titleWeight
+	}
+	for _, term := range doc.description {
+		frequencies[term]++
+	}
+	doc.length = float64(titleWeight*len(doc.title) + len(doc.description))
+
+	for term, f := range frequencies {
+		postings, ok := s.postings[term]
+		if !ok {
+			postings = make(map[models.TaskID]float64)
+			s.postings[term] = postings
+			i := sort.SearchStrings(s.terms, term)
+			s.terms = append(s.terms, "")
+			copy(s.terms[i+1:], s.terms[i:])
+			s.terms[i] = term
+		}
+		postings[task.ID] = f
+	}
+	s.docs[task.ID] = doc
+	s.totalLength += doc.length
+}
+
+// remove drops a task from the index; callers must hold s.mu or own s
+// exclusively
+func (s *SearchIndex) remove(id models.TaskID) {
+	doc, ok := s.docs[id]
+	if !ok {
+		return
+	}
+
+	for _, terms := range [][]string{doc.title, doc.description} {
+		for _, term := range terms {
+			postings, ok := s.postings[term]
+			if !ok {
+				continue
+			}
+			delete(postings, id)
+			if len(postings) == 0 {
+				delete(s.postings, term)
+				i := sort.SearchStrings(s.terms, term)
+				s.terms = append(s.terms[:i], s.terms[i+1:]...)
+			}
+		}
+	}
+	delete(s.docs, id)
+	s.totalLength -= doc.length
+}
+
+// index, unindex and replaced are the write hooks
+func (s *SearchIndex) index(task models.Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(task)
+}
+
+func (s *SearchIndex) unindex(id models.TaskID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(id)
+}
+
+func (s *SearchIndex) replaced(tasks []models.Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+	for _, task := range tasks {
+		s.put(task)
+	}
+}
+
+// SearchHit is one task found by Search
+type SearchHit struct {
+	Task       models.Task     `json:"task"`
+	Score      float64         `json:"score"`
+	Highlights SearchHighlight `json:"highlights"`
+}
+
+// SearchHighlight holds HTML snippets of the matched fields, with the
+// matching words wrapped in <mark> and everything else escaped
+type SearchHighlight struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+// SearchResult is one page of hits, best first
+type SearchResult struct {
+	Hits  []SearchHit
+	Total int
+}
+
+// searchTerm is one word, prefix or phrase of a search query. Each
+// position lists the index terms it accepts: one for a word, every term
+// starting with it for a prefix.
+type searchTerm struct {
+	words  []string
+	prefix bool // the last word is a prefix
+}
+
+// parseSearch reads words, prefixes written as word* and "quoted phrases".
+// Words joined by punctuation (e.g. roll-back) also form a phrase.
+func parseSearch(query string) []searchTerm {
+	var terms []searchTerm
+	add := func(text string, prefix bool) {
+		if words := termsOf(text); len(words) > 0 {
+			terms = append(terms, searchTerm{words: words, prefix: prefix})
+		}
+	}
+
+	for rest := query; rest != ""; {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		if strings.HasPrefix(rest, `"`) {
+			// An unterminated phrase runs to the end
+			phrase := rest[1:]
+			rest = ""
+			if end := strings.Index(phrase, `"`); end >= 0 {
+				phrase, rest = phrase[:end], phrase[end+1:]
+			}
+			add(phrase, false)
+			continue
+		}
+
+		end := strings.IndexFunc(rest, func(r rune) bool { return unicode.IsSpace(r) || r == '"' })
+		if end < 0 {
+			end = len(rest)
+		}
+		word := rest[:end]
+		rest = rest[end:]
+		add(word, strings.HasSuffix(word, "*"))
+	}
+	return terms
+}
+
+// expand returns the index terms a word accepts; callers must hold s.mu
+func (s *SearchIndex) expand(word string, prefix bool) []string {
+	if !prefix {
+		if _, ok := s.postings[word]; ok {
+			return []string{word}
+		}
+		return nil
+	}
+
+	var terms []string
+	for i := sort.SearchStrings(s.terms, word); i < len(s.terms) && strings.HasPrefix(s.terms[i], word); i++ {
+		terms = append(terms, s.terms[i])
+	}
+	return terms
+}
+
+// Search finds the tasks matching every word, prefix and phrase of query
+// in their title or description, ranked by BM25, and returns the hits
+// from offset on, at most limit of them
+func (s *SearchIndex) Search(ctx context.Context, query string, limit, offset int) (*SearchResult, error) {
+	terms := parseSearch(query)
+	if len(terms) == 0 {
+		return nil, ErrEmptySearch
+	}
+
+	hits, marked := s.rank(terms)
+	result := &SearchResult{Hits: []SearchHit{}, Total: len(hits)}
+	if offset >= len(hits) {
+		return result, nil
+	}
+	hits = hits[offset:]
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	// Read outside the index lock; a task deleted or trashed since it was
+	// ranked is left out
+	for _, hit := range hits {
+		task, err := s.repo.Get(ctx, hit.Task.ID)
+		if errors.Is(err, ErrTaskNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if task.DeletedAt != nil {
+			continue
+		}
+		hit.Task = *task
+		hit.Highlights = SearchHighlight{
+			Title:       snippet(task.Title, tokenize(task.Title), marked, false),
+			Description: snippet(task.Description, tokenize(task.Description), marked, true),
+		}
+		result.Hits = append(result.Hits, hit)
+	}
+	return result, nil
+}
+
+// rank returns every task matching terms best first, with only the ID of
+// the task set, and the index terms to mark in snippets
+func (s *SearchIndex) rank(terms []searchTerm) ([]SearchHit, map[string]bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// The accepted index terms per word of every query term
+	expanded := make([][][]string, len(terms))
+	marked := map[string]bool{}
+	for i, term := range terms {
+		expanded[i] = make([][]string, len(term.words))
+		for j, word := range term.words {
+			accepted := s.expand(word, term.prefix && j == len(term.words)-1)
+			if len(accepted) == 0 {
+				return nil, marked
+			}
+			expanded[i][j] = accepted
+			for _, t := range accepted {
+				marked[t] = true
+			}
+		}
+	}
+
+	var hits []SearchHit
+	for id := range s.candidates(expanded) {
+		doc := s.docs[id]
+		if !s.matchesPhrases(doc, terms, expanded) {
+			continue
+		}
+		hits = append(hits, SearchHit{Task: models.Task{ID: id}, Score: s.score(id, doc, expanded)})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Task.ID.Less(hits[j].Task.ID)
+	})
+	return hits, marked
+}
+
+// candidates returns the tasks holding an accepted term for every word;
+// callers must hold s.mu
+func (s *SearchIndex) candidates(expanded [][][]string) map[models.TaskID]bool {
+	var result map[models.TaskID]bool
+	for _, words := range expanded {
+		for _, accepted := range words {
+			found := map[models.TaskID]bool{}
+			for _, term := range accepted {
+				for id := range s.postings[term] {
+					if result == nil || result[id] {
+						found[id] = true
+					}
+				}
+			}
+			result = found
+		}
+	}
+	return result
+}
+
+// matchesPhrases checks that the words of every phrase follow each other
+// in the title or the description
+func (s *SearchIndex) matchesPhrases(doc *searchDoc, terms []searchTerm, expanded [][][]string) bool {
+	for i, term := range terms {
+		if len(term.words) > 1 && !containsPhrase(doc.title, expanded[i]) && !containsPhrase(doc.description, expanded[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsPhrase(terms []string, words [][]string) bool {
+	for start := 0; start+len(words) <= len(terms); start++ {
+		match := true
+		for k, accepted := range words {
+			if !containsString(accepted, terms[start+k]) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// score sums the BM25 weight of every query word in the task, using the
+// best of the terms a prefix accepts; callers must hold s.mu
+func (s *SearchIndex) score(id models.TaskID, doc *searchDoc, expanded [][][]string) float64 {
+	n := float64(len(s.docs))
+	avgLength := s.totalLength / n
+	if avgLength == 0 {
+		avgLength = 1
+	}
+
+	total := 0.0
+	for _, words := range expanded {
+		for _, accepted := range words {
+			best := 0.0
+			for _, term := range accepted {
+				postings := s.postings[term]
+				tf := postings[id]
+				if tf == 0 {
+					continue
+				}
+				df := float64(len(postings))
+				idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+				weight := idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*doc.length/avgLength))
+				best = math.Max(best, weight)
+			}
+			total += best
+		}
+	}
+	return math.Round(total*1000) / 1000
+}
+
+// snippet returns text as escaped HTML with the marked words wrapped in
+// <mark>. When cut is set only a window of words around the first match
+// is kept, with an ellipsis where text was left out.
+func snippet(text string, tokens []searchToken, marked map[string]bool, cut bool) string {
+	from, to := 0, len(tokens)
+	if cut && len(tokens) > snippetWords {
+		first := 0
+		for i, t := range tokens {
+			if marked[t.term] {
+				first = i
+				break
+			}
+		}
+		from = first - snippetBefore
+		if from < 0 {
+			from = 0
+		}
+		to = from + snippetWords
+		if to > len(tokens) {
+			to, from = len(tokens), len(tokens)-snippetWords
+		}
+	}
+
+	var b strings.Builder
+	start, end := 0, len(text)
+	if from > 0 {
+		b.WriteString("…")
+		start = tokens[from].start
+	}
+	if to < len(tokens) {
+		end = tokens[to-1].end
+	}
+
+	pos := start
+	for _, t := range tokens[from:to] {
+		if !marked[t.term] {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:t.start]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[t.start:t.end]))
+		b.WriteString("</mark>")
+		pos = t.end
+	}
+	b.WriteString(html.EscapeString(text[pos:end]))
+	if to < len(tokens) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"gin-framework/database"
+	"log"
+	"time"
+)
+
+// Snapshot labels
+const (
+	SnapshotManual    = "manual"
+	SnapshotScheduled = "scheduled"
+)
+
+// checkpointer is a backend that holds acknowledged writes outside the
+// database file, like the WAL backend, and can flush them into it
+type checkpointer interface {
+	Checkpoint() error
+}
+
+// restorer is a backend that must drop more than its cache when the
+// database file is replaced
+type restorer interface {
+	Restored(tx *database.Tx) error
+}
+
+// reloader is a backend that caches the database file in memory
+type reloader interface {
+	Reload(tx *database.Tx) error
+}
+
+// Snapshotter takes and restores point-in-time copies of the database
+// file behind a backend, keeping the backend's own state in step
+type Snapshotter struct {
+	db   *database.JSONDatabase
+	repo TaskRepository
+	dir  string
+}
+
+func NewSnapshotter(db *database.JSONDatabase, repo TaskRepository, dir string) *Snapshotter {
+	return &Snapshotter{db: db, repo: repo, dir: dir}
+}
+
+// Dir returns the directory the snapshots are kept in
+func (s *Snapshotter) Dir() string {
+	return s.dir
+}
+
+// Create writes a new snapshot with the given label
+func (s *Snapshotter) Create(label string) (*database.SnapshotInfo, error) {
+	if c, ok := s.repo.(checkpointer); ok {
+		if err := c.Checkpoint(); err != nil {
+			return nil, err
+		}
+	}
+	return s.db.Snapshot(s.dir, label)
+}
+
+// List returns the snapshots newest first
+func (s *Snapshotter) List() ([]database.SnapshotInfo, error) {
+	return database.ListSnapshots(s.dir)
+}
+
+// Restore replaces the database with the named snapshot and reloads the
+// backend. It returns database.ErrSnapshotNotFound for an unknown name.
+func (s *Snapshotter) Restore(name string) error {
+	var reload database.ReloadFunc
+	switch r := s.repo.(type) {
+	case restorer:
+		reload = r.Restored
+	case reloader:
+		reload = r.Reload
+	}
+	return s.db.Restore(s.dir, name, reload)
+}
+
+// Every creates a scheduled snapshot every interval and deletes all but
+// the newest keep scheduled ones. Manual snapshots are never deleted. The
+// returned function stops it.
+func (s *Snapshotter) Every(interval time.Duration, keep int) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				s.scheduled(keep)
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+func (s *Snapshotter) scheduled(keep int) {
+	info, err := s.Create(SnapshotScheduled)
+	if err != nil {
+		log.Printf("ERROR: scheduled snapshot: %v", err)
+		return
+	}
+	log.Printf("Created snapshot %s (%d bytes)", info.Name, info.Size)
+
+	removed, err := database.PruneSnapshots(s.dir, SnapshotScheduled, keep)
+	if err != nil {
+		log.Printf("ERROR: rotating snapshots: %v", err)
+	}
+	for _, name := range removed {
+		log.Printf("Deleted old snapshot %s", name)
+	}
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"gin-framework/database"
+	"gin-framework/models"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// restoreFixture is a JSON backend with its revision journal and history,
+// holding task 1 "one" in a snapshot, and "two" plus task 2 after it
+type restoreFixture struct {
+	history   *HistoryRepository
+	snapshots *Snapshotter
+	snapshot  string
+	task      models.TaskID
+	dropped   models.TaskID
+}
+
+func newRestoreFixture(t *testing.T) *restoreFixture {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := database.Open(filepath.Join(dir, "db.json"), nil)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	revisions, err := NewJournalRevisionStore(db, filepath.Join(dir, "revisions.jsonl"))
+	if err != nil {
+		t.Fatalf("NewJournalRevisionStore: %v", err)
+	}
+	t.Cleanup(func() { revisions.Close() })
+
+	repo := NewJSONTaskRepository(db, SequenceGenerator{})
+	f := &restoreFixture{
+		history:   NewHistoryRepository(repo, revisions),
+		snapshots: NewSnapshotter(db, repo, filepath.Join(dir, "snapshots")),
+	}
+	ctx := context.Background()
+
+	task := &models.Task{Title: "one"}
+	if err := f.history.Create(ctx, task); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	f.task = task.ID
+	info, err := f.snapshots.Create(SnapshotManual)
+	if err != nil {
+		t.Fatalf("Create snapshot: %v", err)
+	}
+	f.snapshot = info.Name
+
+	f.rename(t, "two")
+	dropped := &models.Task{Title: "dropped"}
+	if err := f.history.Create(ctx, dropped); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	f.dropped = dropped.ID
+
+	if err := f.snapshots.Restore(f.snapshot); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	return f
+}
+
+func (f *restoreFixture) rename(t *testing.T, title string) *models.Task {
+	t.Helper()
+	updated, err := f.history.Update(context.Background(), f.task, func(task *models.Task) error {
+		task.Title = title
+		task.UpdatedAt = time.Now()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	return updated
+}
+
+func (f *restoreFixture) revisions(t *testing.T, id models.TaskID) []models.Revision {
+	t.Helper()
+	revs, err := f.history.History(context.Background(), id)
+	if err != nil {
+		t.Fatalf("History(%s): %v", id, err)
+	}
+	return revs
+}
+
+func TestReconcileAfterRestore(t *testing.T) {
+	f := newRestoreFixture(t)
+	ctx := context.Background()
+
+	n, err := f.history.Reconcile(ctx)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("Reconcile caught up %d task(s), want 2", n)
+	}
+
+	revs := f.revisions(t, f.task)
+	last := revs[len(revs)-1]
+	if last.Action != models.ActionImport || last.Task.Title != "one" || last.Task.Version != 1 {
+		t.Fatalf("last revision after the restore = %s %q v%d, want import \"one\" v1", last.Action, last.Task.Title, last.Task.Version)
+	}
+	current, err := f.history.GetAsOf(ctx, f.task, time.Now())
+	if err != nil || current.Title != "one" {
+		t.Fatalf("GetAsOf(now) = %v, %v, want the restored task", current, err)
+	}
+	if _, err := f.history.GetAsOf(ctx, f.dropped, time.Now()); !errors.Is(err, ErrTaskNotFound) {
+		t.Fatalf("GetAsOf(now) of a task the snapshot does not have: %v, want ErrTaskNotFound", err)
+	}
+	if revs := f.revisions(t, f.dropped); revs[len(revs)-1].Action != models.ActionPurge {
+		t.Fatalf("last revision of a task the snapshot does not have is %s, want purge", revs[len(revs)-1].Action)
+	}
+
+	// The next change diffs from the restored state
+	updated := f.rename(t, "three")
+	revs = f.revisions(t, f.task)
+	last = revs[len(revs)-1]
+	if updated.Version != 2 || last.Task.Version != 2 || len(last.Changes) == 0 || last.Changes[0].From != "one" {
+		t.Fatalf("update after the restore recorded v%d with changes %+v, want v2 from \"one\"", last.Task.Version, last.Changes)
+	}
+
+	if n, err := f.history.Reconcile(ctx); err != nil || n != 0 {
+		t.Fatalf("second Reconcile = %d, %v, want nothing to catch up", n, err)
+	}
+}
+
+func TestUpdateAfterRestoreBeforeReconcile(t *testing.T) {
+	f := newRestoreFixture(t)
+
+	f.rename(t, "three")
+	revs := f.revisions(t, f.task)
+	if len(revs) != 4 {
+		t.Fatalf("got %d revisions, want create, update, import and update", len(revs))
+	}
+	if revs[2].Action != models.ActionImport || revs[2].Task.Title != "one" {
+		t.Fatalf("revision 3 = %s %q, want an import of the restored state", revs[2].Action, revs[2].Task.Title)
+	}
+	if revs[3].Changes[0].From != "one" {
+		t.Fatalf("update after the restore changed title from %v, want \"one\"", revs[3].Changes[0].From)
+	}
+}
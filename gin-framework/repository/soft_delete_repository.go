@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"context"
+	"gin-framework/models"
+	"log"
+	"sort"
+	"time"
+)
+
+// SoftDeleteRepository wraps any backend so that Delete moves a task to
+// the trash instead of removing it. Trashed tasks keep their ID, are
+// hidden from Get, List and Update, and can be restored until they are
+// purged.
+type SoftDeleteRepository struct {
+	repo TaskRepository
+}
+
+func NewSoftDeleteRepository(repo TaskRepository) *SoftDeleteRepository {
+	return &SoftDeleteRepository{repo: repo}
+}
+
+func trashed(task models.Task) bool {
+	return task.DeletedAt != nil
+}
+
+func (r *SoftDeleteRepository) Get(ctx context.Context, id models.TaskID) (*models.Task, error) {
+	task, err := r.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if trashed(*task) {
+		return nil, ErrTaskNotFound
+	}
+	return task, nil
+}
+
+func (r *SoftDeleteRepository) List(ctx context.Context) ([]models.Task, error) {
+	tasks := []models.Task{}
+	err := r.Scan(ctx, func(task models.Task) bool {
+		tasks = append(tasks, task)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// Scan skips trashed tasks
+func (r *SoftDeleteRepository) Scan(ctx context.Context, fn func(task models.Task) bool) error {
+	return r.repo.Scan(ctx, func(task models.Task) bool {
+		return trashed(task) || fn(task)
+	})
+}
+
+func (r *SoftDeleteRepository) Create(ctx context.Context, task *models.Task) error {
+	task.DeletedAt = nil
+	return r.repo.Create(ctx, task)
+}
+
+// Update leaves trashed tasks alone; restore them first
+func (r *SoftDeleteRepository) Update(ctx context.Context, id models.TaskID, fn func(task *models.Task) error) (*models.Task, error) {
+	return r.repo.Update(ctx, id, func(task *models.Task) error {
+		if trashed(*task) {
+			return ErrTaskNotFound
+		}
+		if err := fn(task); err != nil {
+			return err
+		}
+		// Only Delete and Restore move tasks in and out of the trash
+		task.DeletedAt = nil
+		return nil
+	})
+}
+
+// Delete moves the task to the trash
+func (r *SoftDeleteRepository) Delete(ctx context.Context, id models.TaskID) error {
+	return r.DeleteIf(ctx, id, nil)
+}
+
+// DeleteIf moves the task to the trash if check, when not nil, accepts its
+// current state. An error from check is returned as is.
+func (r *SoftDeleteRepository) DeleteIf(ctx context.Context, id models.TaskID, check func(task models.Task) error) error {
+	_, err := r.repo.Update(ctx, id, func(task *models.Task) error {
+		if trashed(*task) {
+			return ErrTaskNotFound
+		}
+		if check != nil {
+			if err := check(*task); err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		task.DeletedAt = &now
+		return nil
+	})
+	return err
+}
+
+// DeleteMatching permanently removes matching tasks, trashed or not
+func (r *SoftDeleteRepository) DeleteMatching(ctx context.Context, match func(task models.Task) bool) (int, error) {
+	return r.repo.DeleteMatching(ctx, match)
+}
+
+// Trash returns the trashed tasks, most recently deleted first
+func (r *SoftDeleteRepository) Trash(ctx context.Context) ([]models.Task, error) {
+	tasks := []models.Task{}
+	err := r.repo.Scan(ctx, func(task models.Task) bool {
+		if trashed(task) {
+			tasks = append(tasks, task)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return tasks[i].DeletedAt.After(*tasks[j].DeletedAt)
+	})
+	return tasks, nil
+}
+
+// Restore takes a task out of the trash. It returns ErrTaskNotFound when
+// the task is not in the trash.
+func (r *SoftDeleteRepository) Restore(ctx context.Context, id models.TaskID) (*models.Task, error) {
+	return r.repo.Update(ctx, id, func(task *models.Task) error {
+		if !trashed(*task) {
+			return ErrTaskNotFound
+		}
+		task.DeletedAt = nil
+		task.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+// Purge permanently removes one trashed task. Tasks that are not in the
+// trash are left alone and ErrTaskNotFound is returned.
+func (r *SoftDeleteRepository) Purge(ctx context.Context, id models.TaskID) error {
+	n, err := r.repo.DeleteMatching(ctx, func(task models.Task) bool {
+		return task.ID == id && trashed(task)
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTaskNotFound
+	}
+	return nil
+}
+
+// PurgeDeletedBefore permanently removes the tasks trashed before cutoff
+// and reports how many there were
+func (r *SoftDeleteRepository) PurgeDeletedBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	return r.repo.DeleteMatching(ctx, func(task models.Task) bool {
+		return trashed(task) && task.DeletedAt.Before(cutoff)
+	})
+}
+
+// PurgeEvery runs PurgeDeletedBefore every interval for tasks that have
+// been in the trash longer than retention. The returned function stops it.
+func (r *SoftDeleteRepository) PurgeEvery(interval, retention time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				n, err := r.PurgeDeletedBefore(context.Background(), time.Now().Add(-retention))
+				if err != nil {
+					log.Printf("ERROR: purging trash: %v", err)
+				} else if n > 0 {
+					log.Printf("Purged %d task(s) from the trash", n)
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"gin-framework/models"
+	"sort"
+	"time"
+)
+
+// taskIndex holds tasks in memory keyed by ID, with secondary indexes on
+// Completed and CreatedAt. It is not safe for concurrent use; the
+// repositories embedding it guard it with their own lock.
+type taskIndex struct {
+	byID      map[models.TaskID]models.Task
+	ids       []models.TaskID // all IDs in ascending order
+	completed map[bool]map[models.TaskID]struct{}
+	byCreated []models.TaskID // IDs ordered by CreatedAt, then ID
+}
+
+func newTaskIndex() *taskIndex {
+	return &taskIndex{
+		byID: make(map[models.TaskID]models.Task),
+		completed: map[bool]map[models.TaskID]struct{}{
+			true:  {},
+			false: {},
+		},
+	}
+}
+
+// load replaces the whole content of the index
+func (x *taskIndex) load(tasks []models.Task) {
+	*x = *newTaskIndex()
+	for _, task := range tasks {
+		x.put(task)
+	}
+}
+
+func (x *taskIndex) get(id models.TaskID) (models.Task, bool) {
+	task, ok := x.byID[id]
+	return task, ok
+}
+
+func (x *taskIndex) len() int {
+	return len(x.byID)
+}
+
+// put inserts or replaces a task and keeps every index in step
+func (x *taskIndex) put(task models.Task) {
+	if old, ok := x.byID[task.ID]; ok {
+		delete(x.completed[old.Completed], old.ID)
+		x.byCreated = removeID(x.byCreated, x.createdPos(old))
+	} else {
+		x.ids = insertID(x.ids, x.idPos(task.ID), task.ID)
+	}
+
+	x.byID[task.ID] = task
+	x.completed[task.Completed][task.ID] = struct{}{}
+	x.byCreated = insertID(x.byCreated, x.createdPos(task), task.ID)
+}
+
+// remove deletes a task; it reports false when the ID is unknown
+func (x *taskIndex) remove(id models.TaskID) bool {
+	task, ok := x.byID[id]
+	if !ok {
+		return false
+	}
+
+	x.ids = removeID(x.ids, x.idPos(id))
+	x.byCreated = removeID(x.byCreated, x.createdPos(task))
+	delete(x.completed[task.Completed], id)
+	delete(x.byID, id)
+	return true
+}
+
+// all returns every task ordered by ID
+func (x *taskIndex) all() []models.Task {
+	tasks := make([]models.Task, 0, len(x.ids))
+	for _, id := range x.ids {
+		tasks = append(tasks, x.byID[id])
+	}
+	return tasks
+}
+
+// byCompleted returns the tasks with the given Completed value ordered by ID
+func (x *taskIndex) byCompleted(completed bool) []models.Task {
+	tasks := make([]models.Task, 0, len(x.completed[completed]))
+	for id := range x.completed[completed] {
+		tasks = append(tasks, x.byID[id])
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID.Less(tasks[j].ID) })
+	return tasks
+}
+
+// createdBetween returns the tasks created in [from, to) oldest first.
+// A zero bound is open.
+func (x *taskIndex) createdBetween(from, to time.Time) []models.Task {
+	start := 0
+	if !from.IsZero() {
+		start = sort.Search(len(x.byCreated), func(i int) bool {
+			return !x.byID[x.byCreated[i]].CreatedAt.Before(from)
+		})
+	}
+	end := len(x.byCreated)
+	if !to.IsZero() {
+		end = sort.Search(len(x.byCreated), func(i int) bool {
+			return !x.byID[x.byCreated[i]].CreatedAt.Before(to)
+		})
+	}
+
+	var tasks []models.Task
+	for i := start; i < end; i++ {
+		tasks = append(tasks, x.byID[x.byCreated[i]])
+	}
+	return tasks
+}
+
+// idPos finds where id belongs in ids
+func (x *taskIndex) idPos(id models.TaskID) int {
+	return sort.Search(len(x.ids), func(i int) bool { return !x.ids[i].Less(id) })
+}
+
+// createdPos finds where task belongs in byCreated
+func (x *taskIndex) createdPos(task models.Task) int {
+	return sort.Search(len(x.byCreated), func(i int) bool {
+		other := x.byID[x.byCreated[i]]
+		if !other.CreatedAt.Equal(task.CreatedAt) {
+			return other.CreatedAt.After(task.CreatedAt)
+		}
+		return !other.ID.Less(task.ID)
+	})
+}
+
+func insertID(ids []models.TaskID, i int, id models.TaskID) []models.TaskID {
+	ids = append(ids, "")
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	return ids
+}
+
+func removeID(ids []models.TaskID, i int) []models.TaskID {
+	return append(ids[:i], ids[i+1:]...)
+}
@@ -0,0 +1,449 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"gin-framework/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query is a parsed task query such as
+//
+//	completed:false AND (title:~"deploy" OR tag:urgent) AND created>2026-10-01
+//
+// A condition is a field, an operator and a value. Conditions combine
+// with AND (also implied between adjacent conditions), OR and NOT, in
+// that order of precedence, and parentheses group them.
+type Query struct {
+	text  string
+	match func(task models.Task) bool
+}
+
+// QueryError is a syntax or value error at a position in the query text
+type QueryError struct {
+	Position int // 1-based, in characters
+	Message  string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Position, e.Message)
+}
+
+// Match reports whether task satisfies the query
+func (q *Query) Match(task models.Task) bool {
+	return q.match(task)
+}
+
+func (q *Query) String() string {
+	return q.text
+}
+
+// Query operators
+const (
+	opEqual        = ":"
+	opContains     = ":~"
+	opGreater      = ">"
+	opGreaterEqual = ">="
+	opLess         = "<"
+	opLessEqual    = "<="
+)
+
+// Longest first, so ":~" is not read as ":"
+var queryOperators = []string{opContains, opGreaterEqual, opLessEqual, opEqual, opGreater, opLess}
+
+// queryField compiles a condition on one task field. The error is
+// reported at the value; errOperator is reported at the operator.
+type queryField func(op, value string) (func(task models.Task) bool, error)
+
+var errOperator = errors.New("operator not supported")
+
+var queryFields = map[string]queryField{
+	"id":          idCondition,
+	"title":       textCondition(func(task models.Task) string { return task.Title }),
+	"description": textCondition(func(task models.Task) string { return task.Description }),
+	"completed":   completedCondition,
+	"tag":         tagCondition,
+	"version":     versionCondition,
+	"created":     timeCondition(func(task models.Task) time.Time { return task.CreatedAt }),
+	"updated":     timeCondition(func(task models.Task) time.Time { return task.UpdatedAt }),
+}
+
+// Field aliases matching the JSON names
+var queryAliases = map[string]string{
+	"created_at": "created",
+	"updated_at": "updated",
+	"tags":       "tag",
+}
+
+// queryOperatorsFor lists what each field accepts, for error messages
+var queryOperatorsFor = map[string]string{
+	"id":          ": > >= < <=",
+	"title":       ": :~",
+	"description": ": :~",
+	"completed":   ":",
+	"tag":         ": :~",
+	"version":     ": > >= < <=",
+	"created":     ": > >= < <=",
+	"updated":     ": > >= < <=",
+}
+
+func queryFieldNames() []string {
+	names := make([]string, 0, len(queryFields))
+	for name := range queryFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseQuery parses a query; a syntax or value error is a *QueryError
+func ParseQuery(text string) (*Query, error) {
+	p := &queryParser{src: []rune(text)}
+	match, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.atEnd() {
+		if p.peek() == ')' {
+			return nil, p.errorf(p.pos, "unexpected \")\" without a matching \"(\"")
+		}
+		return nil, p.errorf(p.pos, "expected AND, OR or the end of the query")
+	}
+	return &Query{text: text, match: match}, nil
+}
+
+// queryParser is a recursive descent parser over the query text:
+//
+//	or        = and { "OR" and }
+//	and       = not { [ "AND" ] not }
+//	not       = "NOT" not | primary
+//	primary   = "(" or ")" | condition
+//	condition = field operator value
+//	value     = '"' chars '"' | chars up to a space or parenthesis
+type queryParser struct {
+	src []rune
+	pos int
+}
+
+func (p *queryParser) errorf(pos int, format string, args ...interface{}) error {
+	return &QueryError{Position: pos + 1, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *queryParser) atEnd() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *queryParser) peek() rune {
+	if p.atEnd() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *queryParser) skipSpace() {
+	for !p.atEnd() && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+func isFieldRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// keyword consumes word (AND, OR, NOT in any case) when it stands alone
+// at the current position
+func (p *queryParser) keyword(word string) bool {
+	end := p.pos + len(word)
+	if end > len(p.src) || !strings.EqualFold(string(p.src[p.pos:end]), word) {
+		return false
+	}
+	if end < len(p.src) && isFieldRune(p.src[end]) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *queryParser) parseOr() (func(task models.Task) bool, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		if !p.keyword("OR") {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(task models.Task) bool { return l(task) || right(task) }
+	}
+}
+
+func (p *queryParser) parseAnd() (func(task models.Task) bool, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		start := p.pos
+		if p.atEnd() || p.peek() == ')' || p.keyword("OR") {
+			p.pos = start
+			return left, nil
+		}
+		p.keyword("AND")
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(task models.Task) bool { return l(task) && right(task) }
+	}
+}
+
+func (p *queryParser) parseNot() (func(task models.Task) bool, error) {
+	p.skipSpace()
+	if p.keyword("NOT") {
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(task models.Task) bool { return !inner(task) }, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (func(task models.Task) bool, error) {
+	p.skipSpace()
+	switch {
+	case p.atEnd():
+		return nil, p.errorf(p.pos, "expected a condition such as title:~word")
+	case p.peek() == ')':
+		return nil, p.errorf(p.pos, "expected a condition before \")\"")
+	case p.peek() == '(':
+		open := p.pos
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.peek() != ')' {
+			return nil, p.errorf(p.pos, "expected \")\" to close the \"(\" at position %d", open+1)
+		}
+		p.pos++
+		return inner, nil
+	}
+	return p.parseCondition()
+}
+
+func (p *queryParser) parseCondition() (func(task models.Task) bool, error) {
+	fieldPos := p.pos
+	for !p.atEnd() && isFieldRune(p.peek()) {
+		p.pos++
+	}
+	name := strings.ToLower(string(p.src[fieldPos:p.pos]))
+	switch name {
+	case "":
+		return nil, p.errorf(p.pos, "expected a field name, found %q", p.peek())
+	case "and", "or":
+		return nil, p.errorf(fieldPos, "expected a condition, found %s", strings.ToUpper(name))
+	}
+
+	opPos := p.pos
+	op := ""
+	for _, candidate := range queryOperators {
+		end := p.pos + len(candidate)
+		if end <= len(p.src) && string(p.src[p.pos:end]) == candidate {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return nil, p.errorf(p.pos, "expected an operator (: :~ > >= < <=) after %q", name)
+	}
+	p.pos += len(op)
+
+	valuePos := p.pos
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	if alias, ok := queryAliases[name]; ok {
+		name = alias
+	}
+	field, ok := queryFields[name]
+	if !ok {
+		return nil, p.errorf(fieldPos, "unknown field %q, use one of %s", name, strings.Join(queryFieldNames(), ", "))
+	}
+	match, err := field(op, value)
+	if err == errOperator {
+		return nil, p.errorf(opPos, "operator %s does not apply to %s, use one of %s", op, name, queryOperatorsFor[name])
+	}
+	if err != nil {
+		return nil, p.errorf(valuePos, "%v", err)
+	}
+	return match, nil
+}
+
+func (p *queryParser) parseValue() (string, error) {
+	if p.peek() == '"' {
+		open := p.pos
+		p.pos++
+		var b strings.Builder
+		for !p.atEnd() {
+			r := p.src[p.pos]
+			p.pos++
+			switch {
+			case r == '"':
+				return b.String(), nil
+			case r == '\\' && !p.atEnd():
+				b.WriteRune(p.src[p.pos])
+				p.pos++
+			default:
+				b.WriteRune(r)
+			}
+		}
+		return "", p.errorf(open, "unterminated string, expected a closing '\"'")
+	}
+
+	start := p.pos
+	for !p.atEnd() && !unicode.IsSpace(p.peek()) && p.peek() != '(' && p.peek() != ')' && p.peek() != '"' {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf(p.pos, "expected a value")
+	}
+	return string(p.src[start:p.pos]), nil
+}
+
+func textCondition(get func(task models.Task) string) queryField {
+	return func(op, value string) (func(task models.Task) bool, error) {
+		switch op {
+		case opEqual:
+			return func(task models.Task) bool { return strings.EqualFold(get(task), value) }, nil
+		case opContains:
+			return func(task models.Task) bool { return containsFold(get(task), value) }, nil
+		}
+		return nil, errOperator
+	}
+}
+
+// tagCondition matches a task with any tag equal to (:) or containing
+// (:~) the value, ignoring case
+func tagCondition(op, value string) (func(task models.Task) bool, error) {
+	var match func(tag string) bool
+	switch op {
+	case opEqual:
+		match = func(tag string) bool { return strings.EqualFold(tag, value) }
+	case opContains:
+		match = func(tag string) bool { return containsFold(tag, value) }
+	default:
+		return nil, errOperator
+	}
+	return func(task models.Task) bool {
+		for _, tag := range task.Tags {
+			if match(tag) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func completedCondition(op, value string) (func(task models.Task) bool, error) {
+	if op != opEqual {
+		return nil, errOperator
+	}
+	completed, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not true or false", value)
+	}
+	return func(task models.Task) bool { return task.Completed == completed }, nil
+}
+
+// compareCondition turns the result of comparing a field with the value
+// into a condition for op
+func compareCondition(op string, compare func(task models.Task) int) (func(task models.Task) bool, error) {
+	var ok func(c int) bool
+	switch op {
+	case opEqual:
+		ok = func(c int) bool { return c == 0 }
+	case opGreater:
+		ok = func(c int) bool { return c > 0 }
+	case opGreaterEqual:
+		ok = func(c int) bool { return c >= 0 }
+	case opLess:
+		ok = func(c int) bool { return c < 0 }
+	case opLessEqual:
+		ok = func(c int) bool { return c <= 0 }
+	default:
+		return nil, errOperator
+	}
+	return func(task models.Task) bool { return ok(compare(task)) }, nil
+}
+
+func idCondition(op, value string) (func(task models.Task) bool, error) {
+	id := models.TaskID(value)
+	return compareCondition(op, func(task models.Task) int { return compareIDs(task.ID, id) })
+}
+
+func versionCondition(op, value string) (func(task models.Task) bool, error) {
+	version, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a whole number", value)
+	}
+	return compareCondition(op, func(task models.Task) int {
+		switch {
+		case task.Version < version:
+			return -1
+		case task.Version > version:
+			return 1
+		}
+		return 0
+	})
+}
+
+// timeCondition compares with an RFC 3339 time or a whole UTC day
+// (2026-10-01): created:2026-10-01 is any time that day,
+// created>2026-10-01 from the next day on and created<2026-10-01 before it
+func timeCondition(get func(task models.Task) time.Time) queryField {
+	return func(op, value string) (func(task models.Task) bool, error) {
+		from, until, err := parseQueryTime(value)
+		if err != nil {
+			return nil, err
+		}
+		return compareCondition(op, func(task models.Task) int {
+			t := get(task)
+			switch {
+			case t.Before(from):
+				return -1
+			case !t.Before(until):
+				return 1
+			}
+			return 0
+		})
+	}
+}
+
+// parseQueryTime returns the span [from, until) value stands for
+func parseQueryTime(value string) (from, until time.Time, err error) {
+	if day, err := time.Parse("2006-01-02", value); err == nil {
+		return day, day.AddDate(0, 0, 1), nil
+	}
+	at, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return from, until, fmt.Errorf("%q is not a date (2026-10-01) or an RFC 3339 time", value)
+	}
+	return at, at.Add(time.Nanosecond), nil
+}
@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"errors"
+	"gin-framework/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func queryTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	at, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return at
+}
+
+func queryTasks(t *testing.T) []models.Task {
+	return []models.Task{
+		{ID: "1", Title: "Deploy api", Tags: []string{"urgent"}, CreatedAt: queryTime(t, "2026-10-05T09:00:00Z")},
+		{ID: "2", Title: "Write docs", Completed: true, Tags: []string{"docs"}, CreatedAt: queryTime(t, "2026-09-30T23:59:59Z")},
+		{ID: "3", Title: "deploy web", Completed: true, CreatedAt: queryTime(t, "2026-10-01T12:00:00Z")},
+		{ID: "4", Title: `Fix "quoted" bug`, Description: "rollback plan", CreatedAt: queryTime(t, "2026-10-02T00:00:00Z")},
+	}
+}
+
+// matchIDs returns the IDs of the tasks q matches, comma-separated
+func matchIDs(t *testing.T, text string) string {
+	t.Helper()
+	q, err := ParseQuery(text)
+	if err != nil {
+		t.Fatalf("ParseQuery(%q): %v", text, err)
+	}
+	var ids []string
+	for _, task := range queryTasks(t) {
+		if q.Match(task) {
+			ids = append(ids, task.ID.String())
+		}
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestParseQueryPrecedence(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`completed:false AND (title:~"deploy" OR tag:urgent) AND created>2026-10-01`, "1"},
+		{"completed:false OR completed:true AND tag:docs", "1,2,4"},
+		{"(completed:false OR completed:true) AND tag:docs", "2"},
+		{"completed:true tag:docs", "2"},
+		{"title:~deploy OR tag:docs tag:urgent", "1,3"},
+		{"NOT completed:true AND title:~deploy", "1"},
+		{"NOT (completed:true AND title:~deploy)", "1,2,4"},
+		{"NOT NOT tag:docs", "2"},
+		{"not completed:true or tag:docs", "1,2,4"},
+		{"title:~deploy AND NOT tags:~urg", "3"},
+		{" ( ( id>=2 ) )  id<4 ", "2,3"},
+	}
+	for _, tt := range tests {
+		if got := matchIDs(t, tt.query); got != tt.want {
+			t.Errorf("%s matched [%s], want [%s]", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseQueryQuoting(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`title:"deploy API"`, "1"},
+		{`title:~"y a"`, "1"},
+		{`title:~"\"quoted\""`, "4"},
+		{`title:~"(x"`, ""},
+		{`description:"rollback plan"`, "4"},
+		{`title:"Fix \"quoted\" bug"`, "4"},
+		{`title:~"\d\o\c"`, "2"},
+		{`title:~"or" OR title:~"AND"`, ""},
+		{`tag:"URGENT"`, "1"},
+	}
+	for _, tt := range tests {
+		if got := matchIDs(t, tt.query); got != tt.want {
+			t.Errorf("%s matched [%s], want [%s]", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseQueryDayRange(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"created:2026-10-01", "3"},
+		{"created>2026-10-01", "1,4"},
+		{"created>=2026-10-01", "1,3,4"},
+		{"created<2026-10-01", "2"},
+		{"created<=2026-10-01", "2,3"},
+		{"created>2026-10-01T12:00:00Z", "1,4"},
+		{"created_at:2026-10-01T12:00:00Z", "3"},
+		{"created<2026-10-02T00:00:00Z", "2,3"},
+	}
+	for _, tt := range tests {
+		if got := matchIDs(t, tt.query); got != tt.want {
+			t.Errorf("%s matched [%s], want [%s]", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseQueryErrorPosition(t *testing.T) {
+	tests := []struct {
+		query    string
+		position int
+		message  string
+	}{
+		{"", 1, "expected a condition"},
+		{"title:~deploy AND", 18, "expected a condition"},
+		{"title:~deploy OR OR tag:x", 18, "found OR"},
+		{"()", 2, `before ")"`},
+		{"(completed:true", 16, `expected ")" to close the "(" at position 1`},
+		{"completed:true)", 15, `without a matching "("`},
+		{"completed:true ) tag:x", 16, `without a matching "("`},
+		{`title:"open`, 7, "unterminated string"},
+		{"title:", 7, "expected a value"},
+		{"title=x", 6, "expected an operator"},
+		{"=x", 1, "expected a field name"},
+		{"label:x", 1, `unknown field "label"`},
+		{`completed:false AND (title:~"deploy" OR label:urgent) AND created>2026-10-01`, 41, `unknown field "label"`},
+		{"completed:~true", 10, "operator :~ does not apply to completed"},
+		{"tag>x", 4, "operator > does not apply to tag"},
+		{"completed:maybe", 11, "not true or false"},
+		{"version>=1.5", 10, "not a whole number"},
+		{"id:1 created>2026-13-01", 14, "not a date"},
+	}
+	for _, tt := range tests {
+		_, err := ParseQuery(tt.query)
+		var qerr *QueryError
+		if !errors.As(err, &qerr) {
+			t.Errorf("ParseQuery(%q) error = %v, want a *QueryError", tt.query, err)
+			continue
+		}
+		if qerr.Position != tt.position || !strings.Contains(qerr.Message, tt.message) {
+			t.Errorf("ParseQuery(%q) error = %v, want position %d: ...%s...", tt.query, err, tt.position, tt.message)
+		}
+	}
+}
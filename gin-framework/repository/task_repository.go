@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"gin-framework/database"
+	"gin-framework/models"
+)
+
+// ErrTaskNotFound is returned when no task has the requested ID
+var ErrTaskNotFound = errors.New("task not found")
+
+// Database collections used by the file-backed repositories
+const (
+	tasksCollection     = "tasks"
+	sequencesCollection = "sequences" // {"tasks": <last issued sequence ID>}
+)
+
+// TaskRepository hides where tasks are stored, so handlers work the same
+// against the JSON file, memory, or any future backend
+type TaskRepository interface {
+	// Get returns the task with the given ID or ErrTaskNotFound
+	Get(ctx context.Context, id models.TaskID) (*models.Task, error)
+	// List returns all tasks ordered by ID
+	List(ctx context.Context) ([]models.Task, error)
+	// Scan calls fn with each task in List order until fn returns false.
+	// Backends reading from disk decode one task at a time instead of
+	// loading them all.
+	Scan(ctx context.Context, fn func(task models.Task) bool) error
+	// Create assigns a new ID and version 1 to task and stores it
+	Create(ctx context.Context, task *models.Task) error
+	// Update loads the task, lets fn modify it and saves the result as one
+	// atomic step, incrementing its version. An error from fn aborts the
+	// update and is returned as is.
+	Update(ctx context.Context, id models.TaskID, fn func(task *models.Task) error) (*models.Task, error)
+	// Delete removes the task with the given ID
+	Delete(ctx context.Context, id models.TaskID) error
+	// DeleteMatching removes every task for which match returns true as
+	// one step and reports how many were removed
+	DeleteMatching(ctx context.Context, match func(task models.Task) bool) (int, error)
+}
+
+// scanTasks is Scan for backends that already hold every task in memory
+func scanTasks(ctx context.Context, tasks []models.Task, fn func(task models.Task) bool) error {
+	for _, task := range tasks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !fn(task) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// readSequence returns the task sequence stored in tx, raised past any
+// numeric ID in tasks
+func readSequence(tx *database.Tx, tasks []models.Task) (uint64, error) {
+	sequences := map[string]uint64{}
+	if err := tx.Collection(sequencesCollection).Read(&sequences); err != nil {
+		return 0, err
+	}
+
+	seq := sequences[tasksCollection]
+	for _, task := range tasks {
+		seqAtLeast(&seq, task.ID)
+	}
+	return seq, nil
+}
+
+// writeSequence stores the task sequence in the same transaction as the
+// tasks that used it
+func writeSequence(tx *database.Tx, seq uint64) error {
+	sequences := map[string]uint64{}
+	if err := tx.Collection(sequencesCollection).Read(&sequences); err != nil {
+		return err
+	}
+	if sequences[tasksCollection] == seq {
+		return nil
+	}
+
+	sequences[tasksCollection] = seq
+	return tx.Collection(sequencesCollection).Write(sequences)
+}
@@ -0,0 +1,258 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"gin-framework/database"
+	"gin-framework/models"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// contractBackend opens one TaskRepository implementation for the
+// contract tests
+type contractBackend struct {
+	name string
+	// file backends keep their tasks in dir/db.json, so they see tasks
+	// stored there before they open and still have them when reopened
+	file bool
+	open func(t *testing.T, dir string) (repo TaskRepository, close func())
+}
+
+// openContractDB opens dir/db.json for a file backend
+func openContractDB(t *testing.T, dir string) *database.JSONDatabase {
+	t.Helper()
+	db, err := database.Open(filepath.Join(dir, "db.json"), nil)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return db
+}
+
+var contractBackends = []contractBackend{
+	{
+		name: "memory",
+		open: func(t *testing.T, dir string) (TaskRepository, func()) {
+			return NewMemoryTaskRepository(SequenceGenerator{}), func() {}
+		},
+	},
+	{
+		name: "json",
+		file: true,
+		open: func(t *testing.T, dir string) (TaskRepository, func()) {
+			db := openContractDB(t, dir)
+			return NewJSONTaskRepository(db, SequenceGenerator{}), func() { db.Close() }
+		},
+	},
+	{
+		name: "indexed",
+		file: true,
+		open: func(t *testing.T, dir string) (TaskRepository, func()) {
+			db := openContractDB(t, dir)
+			repo, err := NewIndexedTaskRepository(db, SequenceGenerator{})
+			if err != nil {
+				t.Fatalf("NewIndexedTaskRepository: %v", err)
+			}
+			return repo, func() { db.Close() }
+		},
+	},
+	{
+		name: "wal",
+		file: true,
+		open: func(t *testing.T, dir string) (TaskRepository, func()) {
+			db := openContractDB(t, dir)
+			wal, err := database.OpenLog(filepath.Join(dir, "db.json.wal"), nil)
+			if err != nil {
+				t.Fatalf("OpenLog: %v", err)
+			}
+			repo, err := NewWALTaskRepository(db, wal, SequenceGenerator{}, 1<<20)
+			if err != nil {
+				t.Fatalf("NewWALTaskRepository: %v", err)
+			}
+			return repo, func() {
+				wal.Close()
+				db.Close()
+			}
+		},
+	},
+}
+
+// runContract runs test against every backend on a fresh directory
+func runContract(t *testing.T, test func(t *testing.T, b contractBackend, dir string)) {
+	for _, b := range contractBackends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			test(t, b, t.TempDir())
+		})
+	}
+}
+
+// openContract opens b on dir and closes it when the test ends
+func openContract(t *testing.T, b contractBackend, dir string) TaskRepository {
+	t.Helper()
+	repo, close := b.open(t, dir)
+	t.Cleanup(close)
+	return repo
+}
+
+func createContractTasks(t *testing.T, repo TaskRepository, titles ...string) []models.TaskID {
+	t.Helper()
+	var ids []models.TaskID
+	for _, title := range titles {
+		task := &models.Task{Title: title}
+		if err := repo.Create(context.Background(), task); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		ids = append(ids, task.ID)
+	}
+	return ids
+}
+
+func listedIDs(t *testing.T, repo TaskRepository) string {
+	t.Helper()
+	tasks, err := repo.List(context.Background())
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	ids := make([]string, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.ID.String()
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestRepositoryUnknownID(t *testing.T) {
+	runContract(t, func(t *testing.T, b contractBackend, dir string) {
+		repo := openContract(t, b, dir)
+		ctx := context.Background()
+		createContractTasks(t, repo, "one")
+
+		if _, err := repo.Get(ctx, "42"); !errors.Is(err, ErrTaskNotFound) {
+			t.Errorf("Get: %v, want ErrTaskNotFound", err)
+		}
+		called := false
+		_, err := repo.Update(ctx, "42", func(task *models.Task) error {
+			called = true
+			return nil
+		})
+		if !errors.Is(err, ErrTaskNotFound) {
+			t.Errorf("Update: %v, want ErrTaskNotFound", err)
+		}
+		if called {
+			t.Error("Update called fn for an unknown ID")
+		}
+		if err := repo.Delete(ctx, "42"); !errors.Is(err, ErrTaskNotFound) {
+			t.Errorf("Delete: %v, want ErrTaskNotFound", err)
+		}
+		if got := listedIDs(t, repo); got != "1" {
+			t.Errorf("List after the failed calls = [%s], want [1]", got)
+		}
+	})
+}
+
+func TestRepositoryListInIDOrder(t *testing.T) {
+	runContract(t, func(t *testing.T, b contractBackend, dir string) {
+		repo := openContract(t, b, dir)
+		createContractTasks(t, repo, strings.Split("a b c d e f g h i j k l", " ")...)
+		if err := repo.Delete(context.Background(), "3"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+
+		// 10 sorts after 9, not after 1
+		if got, want := listedIDs(t, repo), "1,2,4,5,6,7,8,9,10,11,12"; got != want {
+			t.Errorf("List = [%s], want [%s]", got, want)
+		}
+	})
+}
+
+func TestRepositoryListFileWrittenOutOfOrder(t *testing.T) {
+	runContract(t, func(t *testing.T, b contractBackend, dir string) {
+		if !b.file {
+			t.Skip("no file to write")
+		}
+
+		db := openContractDB(t, dir)
+		err := db.Update(func(tx *database.Tx) error {
+			return tx.Collection(tasksCollection).Write([]models.Task{
+				{ID: "10", Title: "ten", Version: 1},
+				{ID: "2", Title: "two", Version: 1},
+				{ID: "9", Title: "nine", Version: 1},
+			})
+		})
+		db.Close()
+		if err != nil {
+			t.Fatalf("writing tasks: %v", err)
+		}
+
+		repo := openContract(t, b, dir)
+		if got := listedIDs(t, repo); got != "2,9,10" {
+			t.Errorf("List = [%s], want [2,9,10]", got)
+		}
+		// New IDs continue after the highest stored one
+		if ids := createContractTasks(t, repo, "eleven"); ids[0] != "11" {
+			t.Errorf("Create assigned ID %s, want 11", ids[0])
+		}
+		if got := listedIDs(t, repo); got != "2,9,10,11" {
+			t.Errorf("List after Create = [%s], want [2,9,10,11]", got)
+		}
+	})
+}
+
+func TestRepositoryDeleteMatching(t *testing.T) {
+	runContract(t, func(t *testing.T, b contractBackend, dir string) {
+		repo := openContract(t, b, dir)
+		ctx := context.Background()
+		createContractTasks(t, repo, "keep", "drop", "keep", "drop", "drop")
+
+		n, err := repo.DeleteMatching(ctx, func(task models.Task) bool { return task.Title == "drop" })
+		if err != nil || n != 3 {
+			t.Fatalf("DeleteMatching = %d, %v, want 3", n, err)
+		}
+		if got := listedIDs(t, repo); got != "1,3" {
+			t.Errorf("List = [%s], want [1,3]", got)
+		}
+
+		n, err = repo.DeleteMatching(ctx, func(task models.Task) bool { return task.Title == "drop" })
+		if err != nil || n != 0 {
+			t.Fatalf("DeleteMatching with no match = %d, %v, want 0", n, err)
+		}
+		if got := listedIDs(t, repo); got != "1,3" {
+			t.Errorf("List = [%s], want [1,3]", got)
+		}
+	})
+}
+
+func TestRepositoryUpdateFnError(t *testing.T) {
+	runContract(t, func(t *testing.T, b contractBackend, dir string) {
+		repo, close := b.open(t, dir)
+		ctx := context.Background()
+		ids := createContractTasks(t, repo, "before")
+
+		errAbort := errors.New("abort")
+		_, err := repo.Update(ctx, ids[0], func(task *models.Task) error {
+			task.Title = "after"
+			return errAbort
+		})
+		if err != errAbort {
+			t.Fatalf("Update: %v, want the error from fn as is", err)
+		}
+
+		check := func(repo TaskRepository) {
+			t.Helper()
+			task, err := repo.Get(ctx, ids[0])
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if task.Title != "before" || task.Version != 1 {
+				t.Errorf("task is %q v%d after a failed update, want \"before\" v1", task.Title, task.Version)
+			}
+		}
+		check(repo)
+		close()
+
+		if b.file {
+			check(openContract(t, b, dir))
+		}
+	})
+}
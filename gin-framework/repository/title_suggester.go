@@ -0,0 +1,460 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"gin-framework/models"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrEmptyPrefix is returned for a suggestion prefix without any letters
+var ErrEmptyPrefix = errors.New("suggestion prefix is empty")
+
+// suggestHalfLife is the age at which a task counts half as much for the
+// ranking of its title
+const suggestHalfLife = 30 * 24 * time.Hour
+
+// MaxSuggestions is the largest limit Suggest serves; every tree node
+// keeps that many of the best titles below it
+const MaxSuggestions = 50
+
+// rankEpoch is the fixed time title ranks are measured from, see titleRank
+var rankEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// TitleSuggester wraps a backend and keeps the titles of the tasks that
+// are not in the trash in a prefix tree, for type-ahead suggestions.
+// Titles are compared case- and diacritic-insensitively, so "Viết báo
+// cáo" and "viet bao cao" are one title. Like SearchIndex it is built on
+// start and updated with each write that goes through it.
+type TitleSuggester struct {
+	*observedRepository
+
+	mu     sync.RWMutex
+	root   *suggestNode
+	titles map[string]map[models.TaskID]titleUse // folded title -> the tasks using it
+	keys   map[models.TaskID]string              // folded title of each task
+	ranks  map[string]float64                    // folded title -> titleRank of its uses
+	bulk   bool                                  // loading; best lists are filled in by finish
+}
+
+// titleUse is one task's spelling of a title and when it last changed
+type titleUse struct {
+	title string
+	used  time.Time
+}
+
+// suggestNode is a prefix tree node over folded text. A title is stored
+// under every word it contains, so a prefix also matches a later word.
+// best and bestFirst hold the highest ranked titles at or below the node,
+// so Suggest does not have to walk the subtree.
+type suggestNode struct {
+	children  map[rune]*suggestNode
+	ends      map[string]bool // folded titles ending here -> whether from their first word
+	best      []string        // the top MaxSuggestions titles, best first
+	bestFirst []string        // the same for titles stored from their first word
+}
+
+func newSuggestNode() *suggestNode {
+	return &suggestNode{children: make(map[rune]*suggestNode), ends: make(map[string]bool)}
+}
+
+// foldText lowercases s and strips its diacritics. đ has no decomposed
+// form and is mapped to d by hand.
+func foldText(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r == 'đ' || r == 'Đ':
+			r = 'd'
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// foldTitle folds a title and collapses its whitespace
+func foldTitle(title string) string {
+	return strings.Join(strings.Fields(foldText(title)), " ")
+}
+
+// foldPrefix folds a prefix like foldTitle but keeps one trailing space,
+// so "write " only matches the whole word
+func foldPrefix(prefix string) string {
+	folded := foldTitle(prefix)
+	if folded != "" && strings.TrimRightFunc(prefix, unicode.IsSpace) != prefix {
+		folded += " "
+	}
+	return folded
+}
+
+// wordStarts returns the byte offsets at which the words of folded start
+func wordStarts(folded string) []int {
+	var starts []int
+	inWord := false
+	for i, r := range folded {
+		alnum := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if alnum && !inWord {
+			starts = append(starts, i)
+		}
+		inWord = alnum
+	}
+	return starts
+}
+
+// NewTitleSuggester collects the titles of every task of repo
+func NewTitleSuggester(repo TaskRepository) (*TitleSuggester, error) {
+	s := &TitleSuggester{}
+	s.observedRepository = newObservedRepository(repo, writeHooks{put: s.index, remove: s.unindex, replace: s.replaced})
+	if err := s.Rebuild(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Rebuild collects the titles from scratch, for when the backend changed
+// without going through the suggester
+func (s *TitleSuggester) Rebuild() error {
+	return s.exclusive(func() error {
+		fresh := &TitleSuggester{}
+		fresh.reset()
+		fresh.bulk = true
+		err := s.repo.Scan(context.Background(), func(task models.Task) bool {
+			fresh.put(task)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		fresh.finish()
+
+		s.mu.Lock()
+		s.root, s.titles, s.keys, s.ranks = fresh.root, fresh.titles, fresh.keys, fresh.ranks
+		s.mu.Unlock()
+		return nil
+	})
+}
+
+// RebuildLater rebuilds in the background; use it from database reload
+// hooks, which run under the database lock the rebuild needs
+func (s *TitleSuggester) RebuildLater() {
+	go func() {
+		if err := s.Rebuild(); err != nil {
+			log.Printf("ERROR: rebuilding title suggestions: %v", err)
+		}
+	}()
+}
+
+// reset empties the tree; callers must hold s.mu or own s exclusively
+func (s *TitleSuggester) reset() {
+	s.root = newSuggestNode()
+	s.titles = make(map[string]map[models.TaskID]titleUse)
+	s.keys = make(map[models.TaskID]string)
+	s.ranks = make(map[string]float64)
+}
+
+// finish fills in the best lists of every node after a bulk load;
+// callers must hold s.mu or own s exclusively
+func (s *TitleSuggester) finish() {
+	var fill func(n *suggestNode)
+	fill = func(n *suggestNode) {
+		for _, child := range n.children {
+			fill(child)
+		}
+		s.rerank(n)
+	}
+	for _, child := range s.root.children {
+		fill(child)
+	}
+	s.bulk = false
+}
+
+// titleRank orders titles like the score Suggest reports. Every use
+// counts 2^(-age/suggestHalfLife), so the ratio of two scores never
+// changes as time passes, and the sum is kept as log2 of the uses'
+// weights measured from rankEpoch instead of now.
+func titleRank(uses map[models.TaskID]titleUse) float64 {
+	max := math.Inf(-1)
+	exps := make([]float64, 0, len(uses))
+	for _, use := range uses {
+		x := float64(use.used.Sub(rankEpoch)) / float64(suggestHalfLife)
+		exps = append(exps, x)
+		max = math.Max(max, x)
+	}
+	sum := 0.0
+	for _, x := range exps {
+		sum += math.Exp2(x - max)
+	}
+	return max + math.Log2(sum)
+}
+
+// ranksAbove reports whether title a is suggested before title b within
+// the same group; callers must hold s.mu
+func (s *TitleSuggester) ranksAbove(a, b string) bool {
+	if s.ranks[a] != s.ranks[b] {
+		return s.ranks[a] > s.ranks[b]
+	}
+	return a < b
+}
+
+// topTitles sorts keys best first, drops repeats and keeps MaxSuggestions
+// of them; callers must hold s.mu
+func (s *TitleSuggester) topTitles(keys []string) []string {
+	sort.Slice(keys, func(i, j int) bool { return s.ranksAbove(keys[i], keys[j]) })
+	top := keys[:0]
+	for i, key := range keys {
+		if i > 0 && key == keys[i-1] {
+			continue
+		}
+		if top = append(top, key); len(top) == MaxSuggestions {
+			break
+		}
+	}
+	return top
+}
+
+// rerank recomputes the best lists of n from the titles ending at n and
+// the lists of its children; callers must hold s.mu
+func (s *TitleSuggester) rerank(n *suggestNode) {
+	var best, bestFirst []string
+	for key, first := range n.ends {
+		best = append(best, key)
+		if first {
+			bestFirst = append(bestFirst, key)
+		}
+	}
+	for _, child := range n.children {
+		best = append(best, child.best...)
+		bestFirst = append(bestFirst, child.bestFirst...)
+	}
+	n.best, n.bestFirst = s.topTitles(best), s.topTitles(bestFirst)
+}
+
+// changed updates the best lists on every path of key after it was added,
+// removed or ranked anew, from the deepest node up. A node whose lists
+// neither held key nor can take it is left alone, and so are the nodes
+// above it, whose lists are drawn from its own. Callers must hold s.mu.
+func (s *TitleSuggester) changed(key string) {
+	if s.bulk {
+		return
+	}
+	for _, start := range wordStarts(key) {
+		// The root is left out: an empty prefix is never looked up
+		var path []*suggestNode
+		for node, r := s.root, []rune(key[start:]); len(r) > 0; r = r[1:] {
+			if node = node.children[r[0]]; node == nil {
+				break
+			}
+			path = append(path, node)
+		}
+		for i := len(path) - 1; i >= 0; i-- {
+			n := path[i]
+			held := containsString(n.best, key) || containsString(n.bestFirst, key)
+			if !held && !s.canEnter(n.best, key) && !s.canEnter(n.bestFirst, key) {
+				break
+			}
+			s.rerank(n)
+			if !held && !containsString(n.best, key) && !containsString(n.bestFirst, key) {
+				break
+			}
+		}
+	}
+}
+
+// canEnter reports whether key would make it into the list top; callers
+// must hold s.mu
+func (s *TitleSuggester) canEnter(top []string, key string) bool {
+	if _, ok := s.ranks[key]; !ok {
+		return false
+	}
+	return len(top) < MaxSuggestions || s.ranksAbove(key, top[len(top)-1])
+}
+
+// put records the title of task, replacing its previous one; trashed
+// tasks are left out. Callers must hold s.mu or own s exclusively.
+func (s *TitleSuggester) put(task models.Task) {
+	s.remove(task.ID)
+	key := foldTitle(task.Title)
+	if task.DeletedAt != nil || key == "" {
+		return
+	}
+
+	uses, ok := s.titles[key]
+	if !ok {
+		uses = make(map[models.TaskID]titleUse)
+		s.titles[key] = uses
+		for _, start := range wordStarts(key) {
+			node := s.root
+			for _, r := range key[start:] {
+				child, ok := node.children[r]
+				if !ok {
+					child = newSuggestNode()
+					node.children[r] = child
+				}
+				node = child
+			}
+			node.ends[key] = start == 0
+		}
+	}
+	uses[task.ID] = titleUse{title: task.Title, used: task.UpdatedAt}
+	s.keys[task.ID] = key
+	s.ranks[key] = titleRank(uses)
+	s.changed(key)
+}
+
+// remove forgets the title of a task, and the title itself once no task
+// uses it; callers must hold s.mu or own s exclusively
+func (s *TitleSuggester) remove(id models.TaskID) {
+	key, ok := s.keys[id]
+	if !ok {
+		return
+	}
+	delete(s.keys, id)
+
+	uses := s.titles[key]
+	delete(uses, id)
+	if len(uses) > 0 {
+		s.ranks[key] = titleRank(uses)
+		s.changed(key)
+		return
+	}
+	delete(s.titles, key)
+	delete(s.ranks, key)
+
+	for _, start := range wordStarts(key) {
+		path := []*suggestNode{s.root}
+		runes := []rune(key[start:])
+		for _, r := range runes {
+			path = append(path, path[len(path)-1].children[r])
+		}
+		delete(path[len(path)-1].ends, key)
+
+		// Prune the nodes nothing ends at or below any more
+		for i := len(path) - 1; i > 0; i-- {
+			node := path[i]
+			if len(node.children) > 0 || len(node.ends) > 0 {
+				break
+			}
+			delete(path[i-1].children, runes[i-1])
+		}
+	}
+	s.changed(key)
+}
+
+// index, unindex and replaced are the write hooks
+func (s *TitleSuggester) index(task models.Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(task)
+}
+
+func (s *TitleSuggester) unindex(id models.TaskID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(id)
+}
+
+func (s *TitleSuggester) replaced(tasks []models.Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+	s.bulk = true
+	for _, task := range tasks {
+		s.put(task)
+	}
+	s.finish()
+}
+
+// TitleSuggestion is an existing title matching a prefix
+type TitleSuggestion struct {
+	Title    string        `json:"title"` // the most recent spelling
+	TaskID   models.TaskID `json:"id"`    // the most recently updated task with it
+	Count    int           `json:"count"` // how many tasks use it
+	LastUsed time.Time     `json:"last_used"`
+	Score    float64       `json:"score"`
+
+	key string // the folded title
+}
+
+// Suggest returns up to limit titles, at most MaxSuggestions, with a word
+// starting with prefix. Titles starting with it come first; within each
+// group titles are ranked by how many tasks use them, each task counting
+// half as much per suggestHalfLife since it was last updated. Only the
+// best lists of the prefix's node are read, however many titles match.
+func (s *TitleSuggester) Suggest(prefix string, limit int) ([]TitleSuggestion, error) {
+	folded := foldPrefix(prefix)
+	if folded == "" {
+		return nil, ErrEmptyPrefix
+	}
+	if limit > MaxSuggestions {
+		limit = MaxSuggestions
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	node := s.root
+	for _, r := range folded {
+		if node = node.children[r]; node == nil {
+			return []TitleSuggestion{}, nil
+		}
+	}
+
+	// The titles starting with the prefix come first; the best of the
+	// others fill up the rest
+	atStart := map[string]bool{}
+	for _, key := range node.bestFirst {
+		atStart[key] = true
+	}
+	for _, key := range node.best {
+		if len(atStart) >= limit {
+			break
+		}
+		if !atStart[key] {
+			atStart[key] = false
+		}
+	}
+
+	now := time.Now()
+	suggestions := make([]TitleSuggestion, 0, len(atStart))
+	for key := range atStart {
+		suggestion := TitleSuggestion{key: key}
+		for id, use := range s.titles[key] {
+			age := now.Sub(use.used)
+			if age < 0 {
+				age = 0
+			}
+			suggestion.Score += math.Exp2(-float64(age) / float64(suggestHalfLife))
+			suggestion.Count++
+			if use.used.After(suggestion.LastUsed) || suggestion.Title == "" {
+				suggestion.Title, suggestion.TaskID, suggestion.LastUsed = use.title, id, use.used
+			}
+		}
+		suggestion.Score = math.Round(suggestion.Score*1000) / 1000
+		suggestions = append(suggestions, suggestion)
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if atStart[a.key] != atStart[b.key] {
+			return atStart[a.key]
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.key < b.key
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions, nil
+}
@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"gin-framework/models"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSuggester(t *testing.T) (*TitleSuggester, *MemoryTaskRepository) {
+	t.Helper()
+	repo := NewMemoryTaskRepository(SequenceGenerator{})
+	s, err := NewTitleSuggester(repo)
+	if err != nil {
+		t.Fatalf("NewTitleSuggester: %v", err)
+	}
+	return s, repo
+}
+
+func suggestedTitles(t *testing.T, s *TitleSuggester, prefix string, limit int) string {
+	t.Helper()
+	suggestions, err := s.Suggest(prefix, limit)
+	if err != nil {
+		t.Fatalf("Suggest(%q): %v", prefix, err)
+	}
+	titles := make([]string, len(suggestions))
+	for i, suggestion := range suggestions {
+		titles[i] = suggestion.Title
+	}
+	return strings.Join(titles, "|")
+}
+
+func TestSuggestRanking(t *testing.T) {
+	s, _ := newTestSuggester(t)
+	ctx := context.Background()
+	day := 24 * time.Hour
+	create := func(title string, age time.Duration) {
+		t.Helper()
+		task := &models.Task{Title: title, UpdatedAt: time.Now().Add(-age)}
+		if err := s.Create(ctx, task); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+
+	create("Viết báo cáo tuần", day)
+	create("viet bao cao tuan", 2*day)
+	create("Báo cáo tài chính", 0)
+	create("Bao bì mẫu", 90*day)
+	create("Gửi báo giá", 0)
+	create("Gửi báo giá", 0)
+
+	tests := []struct {
+		prefix string
+		limit  int
+		want   string
+	}{
+		// Starting with the prefix first, then by score
+		{"bao", 10, "Báo cáo tài chính|Bao bì mẫu|Gửi báo giá|Viết báo cáo tuần"},
+		{"bao", 3, "Báo cáo tài chính|Bao bì mẫu|Gửi báo giá"},
+		{"bao", 1, "Báo cáo tài chính"},
+		{"báo c", 10, "Báo cáo tài chính|Viết báo cáo tuần"},
+		{"bao ", 10, "Báo cáo tài chính|Bao bì mẫu|Gửi báo giá|Viết báo cáo tuần"},
+		{"ba", 10, "Báo cáo tài chính|Bao bì mẫu|Gửi báo giá|Viết báo cáo tuần"},
+		{"gui", 10, "Gửi báo giá"},
+		{"xyz", 10, ""},
+	}
+	for _, tt := range tests {
+		if got := suggestedTitles(t, s, tt.prefix, tt.limit); got != tt.want {
+			t.Errorf("Suggest(%q, %d) = %s, want %s", tt.prefix, tt.limit, got, tt.want)
+		}
+	}
+}
+
+// checkBestLists compares the best lists kept up to date write by write
+// with the ones a bulk load computes for the same tasks
+func checkBestLists(t *testing.T, path string, got, want *suggestNode) {
+	t.Helper()
+	if strings.Join(got.best, "|") != strings.Join(want.best, "|") {
+		t.Fatalf("node %q: best = %v, want %v", path, got.best, want.best)
+	}
+	if strings.Join(got.bestFirst, "|") != strings.Join(want.bestFirst, "|") {
+		t.Fatalf("node %q: bestFirst = %v, want %v", path, got.bestFirst, want.bestFirst)
+	}
+	if len(got.children) != len(want.children) {
+		t.Fatalf("node %q has %d children, want %d", path, len(got.children), len(want.children))
+	}
+	for r, child := range want.children {
+		checkBestLists(t, path+string(r), got.children[r], child)
+	}
+}
+
+func TestSuggestBestListsFollowWrites(t *testing.T) {
+	s, repo := newTestSuggester(t)
+	ctx := context.Background()
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"alpha", "alps", "beta", "bet", "gamma", "game", "a"}
+
+	var ids []models.TaskID
+	for i := 0; i < 3000; i++ {
+		switch op := rng.Intn(10); {
+		case op < 5 || len(ids) == 0:
+			// Few distinct titles, so they collect uses
+			title := fmt.Sprintf("%s %s %d", words[rng.Intn(len(words))], words[rng.Intn(len(words))], rng.Intn(40))
+			task := &models.Task{Title: title, UpdatedAt: time.Now().Add(-time.Duration(rng.Intn(1000)) * time.Hour)}
+			if err := s.Create(ctx, task); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			ids = append(ids, task.ID)
+		case op < 8:
+			id := ids[rng.Intn(len(ids))]
+			_, err := s.Update(ctx, id, func(task *models.Task) error {
+				if rng.Intn(2) == 0 {
+					task.Title = words[rng.Intn(len(words))] + " renamed"
+				}
+				task.UpdatedAt = time.Now().Add(-time.Duration(rng.Intn(1000)) * time.Hour)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+		default:
+			i := rng.Intn(len(ids))
+			if err := s.Delete(ctx, ids[i]); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			ids = append(ids[:i], ids[i+1:]...)
+		}
+	}
+
+	fresh, err := NewTitleSuggester(repo)
+	if err != nil {
+		t.Fatalf("NewTitleSuggester: %v", err)
+	}
+	for r, child := range fresh.root.children {
+		checkBestLists(t, string(r), s.root.children[r], child)
+	}
+}
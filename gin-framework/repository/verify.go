@@ -0,0 +1,241 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"gin-framework/database"
+	"gin-framework/models"
+	"time"
+)
+
+// Violation is one broken models.Task invariant found in the store
+type Violation struct {
+	Location string        `json:"location"` // position in the file, e.g. "tasks[3]"
+	TaskID   models.TaskID `json:"id,omitempty"`
+	Field    string        `json:"field,omitempty"`
+	Problem  string        `json:"problem"`
+	Fix      string        `json:"fix,omitempty"` // what Repair changed; empty when it was left alone
+}
+
+func (v Violation) String() string {
+	s := v.Location
+	if v.TaskID != "" {
+		s += " (id " + v.TaskID.String() + ")"
+	}
+	if v.Field != "" {
+		s += " " + v.Field
+	}
+	s += ": " + v.Problem
+	if v.Fix != "" {
+		s += " -> " + v.Fix
+	}
+	return s
+}
+
+// RepairReport records what Repair found and changed
+type RepairReport struct {
+	Database   string      `json:"database"`
+	RepairedAt time.Time   `json:"repaired_at"`
+	Fixed      int         `json:"fixed"`
+	Remaining  int         `json:"remaining"`
+	Violations []Violation `json:"violations"`
+}
+
+// Verify checks every stored task against the models.Task invariants:
+// a unique, non-empty ID, a title, a version of at least 1, both
+// timestamps set and updated_at not before created_at. The tasks are
+// streamed, so memory use does not grow with their size.
+func Verify(db *database.JSONDatabase) ([]Violation, error) {
+	v := newVerifier(false, nil)
+	index := 0
+	err := db.Collection(tasksCollection).Scan(func(raw json.RawMessage) bool {
+		v.check(index, raw)
+		index++
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.violations, nil
+}
+
+// Repair fixes what can be fixed without guessing: exact duplicate copies
+// are removed, a task sharing or missing an ID gets a new one, missing
+// timestamps are filled from the other one (or now) and an updated_at
+// before created_at is moved up to it. Empty titles and entries that are
+// not tasks at all are only reported. The content before the repair stays
+// in the .bak generation.
+func Repair(db *database.JSONDatabase, ids IDGenerator) (*RepairReport, error) {
+	report := &RepairReport{Database: db.Path(), RepairedAt: time.Now().UTC()}
+
+	err := db.Collection(tasksCollection).Update(func(tx *database.Tx) error {
+		var raws []json.RawMessage
+		if err := tx.Read(&raws); err != nil {
+			return err
+		}
+
+		// New IDs come from the sequence, raised past every stored ID
+		var tasks []models.Task
+		for _, raw := range raws {
+			var task models.Task
+			if json.Unmarshal(raw, &task) == nil {
+				tasks = append(tasks, task)
+			}
+		}
+		seq, err := readSequence(tx, tasks)
+		if err != nil {
+			return err
+		}
+
+		v := newVerifier(true, func() (models.TaskID, error) {
+			return ids.Next(&seq)
+		})
+		repaired := make([]json.RawMessage, 0, len(raws))
+		for i, raw := range raws {
+			out, keep, err := v.check(i, raw)
+			if err != nil {
+				return err
+			}
+			if keep {
+				repaired = append(repaired, out)
+			}
+		}
+
+		report.Violations = v.violations
+		for _, violation := range v.violations {
+			if violation.Fix != "" {
+				report.Fixed++
+			} else {
+				report.Remaining++
+			}
+		}
+		if report.Fixed == 0 {
+			return nil
+		}
+
+		if err := tx.Write(repaired); err != nil {
+			return err
+		}
+		return writeSequence(tx, seq)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if report.Violations == nil {
+		report.Violations = []Violation{}
+	}
+	return report, nil
+}
+
+// verifier checks tasks one at a time, remembering IDs to find duplicates
+type verifier struct {
+	repair     bool
+	nextID     func() (models.TaskID, error)
+	now        time.Time
+	seen       map[models.TaskID]seenTask
+	violations []Violation
+}
+
+type seenTask struct {
+	index int
+	sum   [sha256.Size]byte
+}
+
+func newVerifier(repair bool, nextID func() (models.TaskID, error)) *verifier {
+	return &verifier{repair: repair, nextID: nextID, now: time.Now(), seen: make(map[models.TaskID]seenTask)}
+}
+
+// check inspects the task at index. In repair mode it returns the task
+// with its violations fixed; keep is false for an exact duplicate.
+func (v *verifier) check(index int, raw json.RawMessage) (out json.RawMessage, keep bool, err error) {
+	location := fmt.Sprintf("%s[%d]", tasksCollection, index)
+
+	var task models.Task
+	if err := json.Unmarshal(raw, &task); err != nil {
+		v.violations = append(v.violations, Violation{Location: location, Problem: "not a valid task: " + err.Error()})
+		return raw, true, nil
+	}
+
+	id := task.ID // as found, before a new one is assigned
+	changed := false
+	report := func(field, problem, fix string) {
+		violation := Violation{Location: location, TaskID: id, Field: field, Problem: problem}
+		if v.repair && fix != "" {
+			violation.Fix = fix
+			changed = true
+		}
+		v.violations = append(v.violations, violation)
+	}
+
+	canonical, _ := json.Marshal(task)
+	sum := sha256.Sum256(canonical)
+	reassign := false
+	if task.ID == "" {
+		report("id", "missing", "assign a new id")
+		reassign = true
+	} else if first, ok := v.seen[task.ID]; ok {
+		where := fmt.Sprintf("%s[%d]", tasksCollection, first.index)
+		if first.sum == sum {
+			report("id", "exact duplicate of "+where, "removed")
+			if v.repair {
+				return nil, false, nil
+			}
+		} else {
+			report("id", "duplicate of "+where, "assign a new id")
+			reassign = true
+		}
+	}
+	if reassign && v.repair {
+		if task.ID, err = v.nextID(); err != nil {
+			return nil, false, err
+		}
+		v.violations[len(v.violations)-1].Fix = "assigned new id " + task.ID.String()
+	}
+	if _, ok := v.seen[task.ID]; !ok && task.ID != "" {
+		v.seen[task.ID] = seenTask{index: index, sum: sum}
+	}
+
+	if task.Title == "" {
+		report("title", "empty", "")
+	}
+	if task.Version < 1 {
+		report("version", fmt.Sprintf("%d is below 1", task.Version), "set to 1")
+		if v.repair {
+			task.Version = 1
+		}
+	}
+
+	switch {
+	case task.CreatedAt.IsZero() && task.UpdatedAt.IsZero():
+		report("created_at", "missing", "set to the repair time")
+		report("updated_at", "missing", "set to the repair time")
+		if v.repair {
+			task.CreatedAt, task.UpdatedAt = v.now, v.now
+		}
+	case task.CreatedAt.IsZero():
+		report("created_at", "missing", "set to updated_at")
+		if v.repair {
+			task.CreatedAt = task.UpdatedAt
+		}
+	case task.UpdatedAt.IsZero():
+		report("updated_at", "missing", "set to created_at")
+		if v.repair {
+			task.UpdatedAt = task.CreatedAt
+		}
+	case task.UpdatedAt.Before(task.CreatedAt):
+		report("updated_at", "earlier than created_at", "set to created_at")
+		if v.repair {
+			task.UpdatedAt = task.CreatedAt
+		}
+	}
+
+	if !changed {
+		return raw, true, nil
+	}
+	out, err = json.Marshal(task)
+	if err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
@@ -0,0 +1,473 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gin-framework/database"
+	"gin-framework/models"
+	"log"
+	"sync"
+)
+
+// WALTaskRepository keeps tasks in memory and records each mutation as one
+// appended log record instead of rewriting the whole file. On startup the
+// log is replayed on top of the last snapshot in the JSONDatabase; once
+// the log grows past compactSize a new snapshot is written in the
+// background and the covered records are dropped.
+type WALTaskRepository struct {
+	db          *database.JSONDatabase
+	wal         *database.Log
+	ids         IDGenerator
+	compactSize int64
+
+	mu         sync.RWMutex
+	index      *taskIndex
+	seq        uint64
+	compacting bool
+}
+
+// NewWALTaskRepository loads the snapshot from db and replays wal on top
+func NewWALTaskRepository(db *database.JSONDatabase, wal *database.Log, ids IDGenerator, compactSize int64) (*WALTaskRepository, error) {
+	r := &WALTaskRepository{
+		db:          db,
+		wal:         wal,
+		ids:         ids,
+		compactSize: compactSize,
+		index:       newTaskIndex(),
+	}
+
+	err := db.View(func(tx *database.Tx) error {
+		return r.load(tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// load rebuilds the index from the snapshot and the log; callers must
+// hold r.mu or own r exclusively
+func (r *WALTaskRepository) load(tx *database.Tx) error {
+	var snapshot []models.Task
+	if err := tx.Collection(tasksCollection).Read(&snapshot); err != nil {
+		return err
+	}
+	seq, err := readSequence(tx, snapshot)
+	if err != nil {
+		return err
+	}
+
+	index := newTaskIndex()
+	index.load(snapshot)
+	oldIndex, oldSeq := r.index, r.seq
+	r.index, r.seq = index, seq
+
+	// Replaying the whole log over a newer snapshot is safe: every record
+	// holds the complete state of its task, so the last one wins
+	err = r.wal.Replay(func(rec database.Record) error {
+		return r.apply(rec)
+	})
+	if err != nil {
+		// Keep the state we had
+		r.index, r.seq = oldIndex, oldSeq
+		return err
+	}
+	return nil
+}
+
+// FoldLog writes the records in wal into the tasks stored in db and then
+// drops them, without loading the tasks into a WALTaskRepository first.
+// Entries the log never touched are kept exactly as stored, including ones
+// an integrity check would flag, and the sequence is raised past every
+// logged ID, so Verify and Repair see the state the backend would replay.
+func FoldLog(db *database.JSONDatabase, wal *database.Log) error {
+	if wal.Size() == 0 {
+		return nil
+	}
+
+	logSeq := wal.Seq()
+	var truncateErr error
+	err := db.Collection(tasksCollection).Update(func(tx *database.Tx) error {
+		var raws []json.RawMessage
+		if err := tx.Read(&raws); err != nil {
+			return err
+		}
+		var tasks []models.Task
+		for _, raw := range raws {
+			var task models.Task
+			if json.Unmarshal(raw, &task) == nil {
+				tasks = append(tasks, task)
+			}
+		}
+		seq, err := readSequence(tx, tasks)
+		if err != nil {
+			return err
+		}
+
+		err = wal.Replay(func(rec database.Record) error {
+			id := models.TaskID(rec.Key)
+			if rec.Op == database.OpPut {
+				var task models.Task
+				if err := json.Unmarshal(rec.Data, &task); err != nil {
+					return err
+				}
+				id = task.ID
+				seqAtLeast(&seq, id)
+			}
+			raws = replaceRaw(raws, id, rec)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Write(raws); err != nil {
+			return err
+		}
+		if err := writeSequence(tx, seq); err != nil {
+			return err
+		}
+		tx.OnCommit(func() {
+			truncateErr = wal.TruncateThrough(logSeq)
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if truncateErr != nil {
+		return fmt.Errorf("log folded into %s but not truncated: %w", db.Path(), truncateErr)
+	}
+	return nil
+}
+
+// replaceRaw applies one log record to the stored entries: every entry
+// with its ID is removed, and a put takes the place of the first one or
+// is appended
+func replaceRaw(raws []json.RawMessage, id models.TaskID, rec database.Record) []json.RawMessage {
+	out := raws[:0]
+	placed := false
+	for _, raw := range raws {
+		var entry struct {
+			ID models.TaskID `json:"id"`
+		}
+		if json.Unmarshal(raw, &entry) != nil || entry.ID != id {
+			out = append(out, raw)
+			continue
+		}
+		if rec.Op == database.OpPut && !placed {
+			out = append(out, json.RawMessage(rec.Data))
+			placed = true
+		}
+	}
+	if rec.Op == database.OpPut && !placed {
+		out = append(out, json.RawMessage(rec.Data))
+	}
+	return out
+}
+
+// Reload rebuilds the state after db.json was changed outside the
+// process. Tasks that also appear in the log keep their logged state.
+func (r *WALTaskRepository) Reload(tx *database.Tx) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.load(tx)
+}
+
+// apply replays one log record; callers must hold r.mu or own r exclusively
+func (r *WALTaskRepository) apply(rec database.Record) error {
+	switch rec.Op {
+	case database.OpPut:
+		var task models.Task
+		if err := json.Unmarshal(rec.Data, &task); err != nil {
+			return err
+		}
+		r.index.put(task)
+		// A logged ID stays used even if its task is deleted later
+		seqAtLeast(&r.seq, task.ID)
+	case database.OpDelete:
+		r.index.remove(models.TaskID(rec.Key))
+	}
+	return nil
+}
+
+// commit durably logs rec and then applies it; callers must hold r.mu
+func (r *WALTaskRepository) commit(rec database.Record) error {
+	if err := r.wal.Append(&rec); err != nil {
+		return err
+	}
+	if err := r.apply(rec); err != nil {
+		return err
+	}
+
+	if r.wal.Size() >= r.compactSize && !r.compacting {
+		r.compacting = true
+		go r.compact()
+	}
+	return nil
+}
+
+func putRecord(task models.Task) (database.Record, error) {
+	data, err := json.Marshal(task)
+	if err != nil {
+		return database.Record{}, err
+	}
+	return database.Record{Op: database.OpPut, Key: task.ID.String(), Data: data}, nil
+}
+
+// compact runs Checkpoint in the background once the log is too large
+func (r *WALTaskRepository) compact() {
+	defer func() {
+		r.mu.Lock()
+		r.compacting = false
+		r.mu.Unlock()
+	}()
+
+	if err := r.Checkpoint(); err != nil {
+		log.Printf("WAL compaction failed, keeping log: %v", err)
+	}
+}
+
+// Checkpoint writes the current state as a new snapshot in the database
+// file and then drops the log records it covers. The state is captured,
+// written and truncated inside one database transaction, so a concurrent
+// checkpoint, ReplaceAll or snapshot restore cannot land between the
+// capture and the write and be overwritten by older state. Writers are
+// only blocked while the state is copied, not while the snapshot is
+// written.
+func (r *WALTaskRepository) Checkpoint() error {
+	var truncateErr error
+	err := r.db.Update(func(tx *database.Tx) error {
+		r.mu.RLock()
+		logSeq := r.wal.Seq()
+		tasks := r.index.all()
+		taskSeq := r.seq
+		r.mu.RUnlock()
+
+		if err := tx.Collection(tasksCollection).Write(tasks); err != nil {
+			return err
+		}
+		if err := writeSequence(tx, taskSeq); err != nil {
+			return err
+		}
+		tx.OnCommit(func() {
+			truncateErr = r.wal.TruncateThrough(logSeq)
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if truncateErr != nil {
+		return fmt.Errorf("snapshot written but log not truncated: %w", truncateErr)
+	}
+	return nil
+}
+
+// Restored drops the whole log after the database file was replaced by a
+// snapshot, which already holds the state to continue from, and reloads.
+// It runs inside the restore's transaction, after any checkpoint in
+// progress has finished.
+func (r *WALTaskRepository) Restored(tx *database.Tx) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.wal.TruncateThrough(r.wal.Seq()); err != nil {
+		return err
+	}
+	return r.load(tx)
+}
+
+func (r *WALTaskRepository) Get(ctx context.Context, id models.TaskID) (*models.Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	task, ok := r.index.get(id)
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	return &task, nil
+}
+
+func (r *WALTaskRepository) List(ctx context.Context) ([]models.Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.index.all(), nil
+}
+
+func (r *WALTaskRepository) Scan(ctx context.Context, fn func(task models.Task) bool) error {
+	tasks, err := r.List(ctx)
+	if err != nil {
+		return err
+	}
+	return scanTasks(ctx, tasks, fn)
+}
+
+// ReplaceAll replaces every task with tasks, stored as given, as a new
+// snapshot in the database file. Like Checkpoint it truncates the log in
+// its transaction, so the two never interleave.
+func (r *WALTaskRepository) ReplaceAll(ctx context.Context, tasks []models.Task) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var truncateErr error
+	err := r.db.Update(func(tx *database.Tx) error {
+		r.mu.RLock()
+		seq := r.seq
+		r.mu.RUnlock()
+
+		for _, task := range tasks {
+			seqAtLeast(&seq, task.ID)
+		}
+		if err := tx.Collection(tasksCollection).Write(tasks); err != nil {
+			return err
+		}
+		if err := writeSequence(tx, seq); err != nil {
+			return err
+		}
+
+		tx.OnCommit(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			// Older records must not be replayed over the new snapshot
+			truncateErr = r.wal.TruncateThrough(r.wal.Seq())
+			r.index.load(tasks)
+			r.seq = seq
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// The follower must not take this snapshot as its position, or the
+	// old records replay over it on the next start; it resyncs instead
+	if truncateErr != nil {
+		return fmt.Errorf("snapshot replaced but log not truncated: %w", truncateErr)
+	}
+	return nil
+}
+
+// Apply logs a replicated change as given
+func (r *WALTaskRepository) Apply(ctx context.Context, change models.Change) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkChange(change); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if change.Op == models.ChangeDelete {
+		return r.commit(database.Record{Op: database.OpDelete, Key: change.TaskID.String()})
+	}
+	rec, err := putRecord(*change.Task)
+	if err != nil {
+		return err
+	}
+	return r.commit(rec)
+}
+
+func (r *WALTaskRepository) Create(ctx context.Context, task *models.Task) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := r.ids.Next(&r.seq)
+	if err != nil {
+		return err
+	}
+	task.ID = id
+	task.Version = 1
+
+	rec, err := putRecord(*task)
+	if err != nil {
+		return err
+	}
+	return r.commit(rec)
+}
+
+func (r *WALTaskRepository) Update(ctx context.Context, id models.TaskID, fn func(task *models.Task) error) (*models.Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	task, ok := r.index.get(id)
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	version := task.Version
+	if err := fn(&task); err != nil {
+		return nil, err
+	}
+	task.ID = id
+	task.Version = version + 1
+
+	rec, err := putRecord(task)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.commit(rec); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (r *WALTaskRepository) Delete(ctx context.Context, id models.TaskID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.index.get(id); !ok {
+		return ErrTaskNotFound
+	}
+	return r.commit(database.Record{Op: database.OpDelete, Key: id.String()})
+}
+
+// DeleteMatching logs one delete record per task. A crash part way leaves
+// some of them deleted, which is fine for a repeatable cleanup.
+func (r *WALTaskRepository) DeleteMatching(ctx context.Context, match func(task models.Task) bool) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	removed := 0
+	for _, task := range r.index.all() {
+		if !match(task) {
+			continue
+		}
+		if err := r.commit(database.Record{Op: database.OpDelete, Key: task.ID.String()}); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}